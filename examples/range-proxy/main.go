@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"io"
 	"log"
+	"net"
 	"net/http"
 	_ "net/http/pprof"
 	"net/url"
@@ -22,9 +23,11 @@ import (
 var (
 	optCheckVersion = golf.DurationP('c', "check-version", 15*time.Second, "periodicity to check %version for updates")
 	optHelp         = golf.BoolP('h', "help", false, "display program help then exit")
+	optMaxCache     = golf.IntP('m', "max-cache-bytes", 256<<20, "approximate max bytes of cached responses (0 for no limit)")
 	optPort         = golf.UintP('p', "port", 8081, "port to bind to")
 	optPprof        = golf.Uint("pprof", 0, "pprof port to bind to")
 	optServers      = golf.StringP('s', "servers", "range", "specify comma delimited list of range servers")
+	optSocket       = golf.StringP('u', "socket", "", "path of Unix domain socket to bind to instead of port")
 	optTTE          = golf.DurationP('e', "tte", 12*time.Hour, "max duration prior to cache eviction")
 )
 
@@ -59,8 +62,10 @@ func main() {
 	log.Fatal(Proxy(ProxyConfig{
 		CheckVersionPeriodicity: *optCheckVersion,
 		Log:                     os.Stderr,
+		MaxCacheBytes:           *optMaxCache,
 		Port:                    *optPort,
 		Servers:                 servers,
+		Socket:                  *optSocket,
 		Timeout:                 1 * time.Minute, // how long to wait for downstream to respond
 		TTE:                     *optTTE,
 	}))
@@ -85,6 +90,12 @@ type ProxyConfig struct {
 	// `gohm.DefaultLogFormat`.
 	LogFormat string
 
+	// MaxCacheBytes limits the approximate memory used by cached responses.
+	// Because the proxy caches arbitrary user queries, when the limit is
+	// reached the least recently used responses are evicted.  If the
+	// zero-value, the cache is only limited by TTE.
+	MaxCacheBytes int
+
 	// Port specifies which network port the proxy should bind to.
 	Port uint
 
@@ -92,6 +103,12 @@ type ProxyConfig struct {
 	// truth for range queries.
 	Servers []string
 
+	// Socket specifies the path of a Unix domain socket the proxy should bind
+	// to, allowing clients on the same host to send queries to a server
+	// address such as `unix:///var/run/range-proxy.sock`.  When provided, Port
+	// is ignored.  A socket left behind by a previous proxy is removed.
+	Socket string
+
 	// Timeout specifies how long to wait for the source of truth to respond. If
 	// the zero-value, no timeout will be used. Not having a timeout value may
 	// cause resource exhaustion where any of the proxied servers take too long
@@ -109,11 +126,13 @@ type ProxyConfig struct {
 	TTE time.Duration
 }
 
-// Proxy creates a proxy http server on the port that proxies range queries to
-// the specified range servers.
+// Proxy creates a proxy http server on the port, or Unix domain socket, that
+// proxies range queries to the specified range servers.
 func Proxy(config ProxyConfig) error {
 	querier, err := gorange.NewQuerier(&gorange.Configurator{
 		CheckVersionPeriodicity: config.CheckVersionPeriodicity,
+		MaxCacheBytes:           config.MaxCacheBytes,
+		Metrics:                 gorange.NewExpvarMetrics("gorange"),
 		RetryCount:              len(config.Servers),
 		Servers:                 config.Servers,
 		TTE:                     config.TTE,
@@ -122,9 +141,11 @@ func Proxy(config ProxyConfig) error {
 		return err
 	}
 
+	cq := querier.(gorange.ContextQuerier) // both Client and CachingClient
+
 	mux := http.NewServeMux()
-	mux.Handle("/range/expand", onlyGet(decodeURI(expand(querier, ","))))
-	mux.Handle("/range/list", onlyGet(decodeURI(expand(querier, "\n"))))
+	mux.Handle("/range/expand", onlyGet(decodeURI(expand(cq, ","))))
+	mux.Handle("/range/list", onlyGet(decodeURI(expand(cq, "\n"))))
 	mux.Handle("/", notFound()) // while not required, this makes for a nicer log output and client response
 
 	logBitmask := gohm.LogStatusErrors
@@ -140,7 +161,20 @@ func Proxy(config ProxyConfig) error {
 		ReadTimeout:  15 * time.Second,
 		WriteTimeout: 30 * time.Second,
 	}
-	return server.ListenAndServe()
+	if config.Socket == "" {
+		return server.ListenAndServe()
+	}
+
+	if fi, err := os.Lstat(config.Socket); err == nil && fi.Mode()&os.ModeSocket != 0 {
+		if err = os.Remove(config.Socket); err != nil {
+			return err
+		}
+	}
+	listener, err := net.Listen("unix", config.Socket)
+	if err != nil {
+		return err
+	}
+	return server.Serve(listener)
 }
 
 func notFound() http.Handler {
@@ -186,10 +220,10 @@ func decodeURI(next http.Handler) http.Handler {
 	})
 }
 
-func expand(querier gorange.Querier, delim string) http.Handler {
+func expand(querier gorange.ContextQuerier, delim string) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		query := queryFromContext(r.Context())
-		results, err := querier.Query(query)
+		results, err := querier.QueryContext(r.Context(), query)
 		if err != nil {
 			gohm.Error(w, "cannot resolve query: "+err.Error(), http.StatusBadGateway)
 			return
@@ -0,0 +1,147 @@
+package gorange
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/karrick/goswarm"
+)
+
+// DefaultWarmConcurrency is used when no WarmConcurrency is provided to limit
+// the number of queries a CachingClient sends concurrently while warming its
+// cache.
+const DefaultWarmConcurrency = 8
+
+// CacheEntry describes a single expression held in a CachingClient's cache.
+type CacheEntry struct {
+	// Expression is the query expression.
+	Expression string
+
+	// Lines is the cached list of strings the expression resolved to, or nil
+	// when the cached value is an error.
+	Lines []string
+
+	// Err is the cached error, such as an ErrRangeException, or nil when the
+	// cached value is a list of strings.
+	Err error
+
+	// Stale is the time after which the cached value is refreshed the next
+	// time it is queried, or the zero time when it does not go stale.
+	Stale time.Time
+
+	// Expiry is the time after which the cached value is no longer served, or
+	// the zero time when it does not expire.
+	Expiry time.Time
+
+	// LastRequest is the time the expression was most recently queried.
+	LastRequest time.Time
+}
+
+// Entries invokes callback for each expression in the cache, in no particular
+// order.  The callback receives a copy of each entry, which it may modify.
+//
+//	cc := querier.(*gorange.CachingClient)
+//	cc.Entries(func(entry gorange.CacheEntry) {
+//		fmt.Printf("%s: %d lines, stale at %s\n", entry.Expression, len(entry.Lines), entry.Stale)
+//	})
+func (cc *CachingClient) Entries(callback func(CacheEntry)) {
+	cc.cache.Range(func(key string, tv *goswarm.TimedValue) {
+		entry := CacheEntry{
+			Expression: key,
+			Err:        tv.Err,
+			Stale:      tv.Stale,
+			Expiry:     tv.Expiry,
+		}
+		if result, ok := tv.Value.(*Result); ok {
+			entry.Lines = append([]string(nil), result.Lines...)
+		}
+		if lrt, ok := cc.lastRequestTimes.Load(key); ok {
+			entry.LastRequest = lrt.(time.Time)
+		}
+		callback(entry)
+	})
+}
+
+// Invalidate removes the expression from the cache, so the next query for it
+// is sent to the range servers.
+func (cc *CachingClient) Invalidate(expression string) {
+	cc.forget(expression)
+}
+
+// InvalidatePrefix removes from the cache every expression that begins with
+// prefix, and returns the number of expressions removed.
+func (cc *CachingClient) InvalidatePrefix(prefix string) int {
+	var count int
+	cc.cache.Range(func(key string, _ *goswarm.TimedValue) {
+		if strings.HasPrefix(key, prefix) {
+			cc.forget(key)
+			count++
+		}
+	})
+	return count
+}
+
+// Purge removes every expression from the cache.
+func (cc *CachingClient) Purge() {
+	cc.cache.Range(func(key string, _ *goswarm.TimedValue) {
+		cc.forget(key)
+	})
+}
+
+// Refresh looks up the expression and stores the response in the cache,
+// regardless of whether the cached value is stale, blocking until the lookup
+// completes.  It performs the same lookup as a query that misses the cache, so
+// it joins a query for the expression that is already in flight rather than
+// sending another, and the response is cached using the same durations and
+// NegativeCachePolicy.  When the lookup fails, a cached value that has not
+// expired is retained, and otherwise the error is handled as for a query.  Use
+// QueryResult to inspect the outcome.
+func (cc *CachingClient) Refresh(expression string) {
+	cc.lastRequestTimes.Store(expression, time.Now())
+	cc.cache.Update(expression)
+}
+
+// Warm queries each of the expressions, so their responses are cached before
+// they are needed, such as when a program starts.  At most WarmConcurrency
+// queries are sent concurrently.  Warm continues after a query fails, and
+// returns the first error encountered, or nil when every query succeeds.
+//
+//	cc := querier.(*gorange.CachingClient)
+//	if err := cc.Warm([]string{"%webservers", "%dbservers"}); err != nil {
+//		fmt.Fprintf(os.Stderr, "WARNING: %s\n", err)
+//	}
+func (cc *CachingClient) Warm(expressions []string) error {
+	workers := cc.config.warmConcurrency
+	if workers > len(expressions) {
+		workers = len(expressions)
+	}
+
+	queue := make(chan string)
+	var firstErr error
+	var lock sync.Mutex
+	var wg sync.WaitGroup
+
+	wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go func() {
+			defer wg.Done()
+			for expression := range queue {
+				if _, err := cc.Query(expression); err != nil {
+					lock.Lock()
+					if firstErr == nil {
+						firstErr = err
+					}
+					lock.Unlock()
+				}
+			}
+		}()
+	}
+
+	for _, expression := range expressions {
+		queue <- expression
+	}
+	close(queue)
+	wg.Wait()
+	return firstErr
+}
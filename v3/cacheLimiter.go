@@ -0,0 +1,108 @@
+package gorange
+
+import (
+	"container/list"
+	"sync"
+)
+
+// stringHeaderSize approximates the memory used by a string header, namely its
+// pointer and length, on 64-bit platforms.
+const stringHeaderSize = 16
+
+// cacheLimiter tracks the expressions held in a CachingClient's cache in least
+// recently used order, along with their approximate sizes, and determines which
+// expressions to evict to keep the cache within its configured limits.
+type cacheLimiter struct {
+	maxEntries int               // 0 means no limit
+	maxBytes   int               // 0 means no limit
+	keep       func(string) bool // expressions that must not be evicted
+
+	lock     sync.Mutex
+	bytes    int
+	order    *list.List // of *limiterEntry; front is most recently used
+	elements map[string]*list.Element
+}
+
+type limiterEntry struct {
+	expression string
+	size       int
+}
+
+func newCacheLimiter(maxEntries, maxBytes int, keep func(string) bool) *cacheLimiter {
+	return &cacheLimiter{
+		maxEntries: maxEntries,
+		maxBytes:   maxBytes,
+		keep:       keep,
+		order:      list.New(),
+		elements:   make(map[string]*list.Element),
+	}
+}
+
+// add records that the expression was stored in the cache with the specified
+// size, and returns the least recently used entries that must be evicted to
+// remain within the limits.  The added expression is never evicted, so an
+// entry larger than the byte limit is still cached, on its own.  Neither are
+// expressions for which keep returns true, so the limits are exceeded when
+// no other expressions remain to evict.
+func (cl *cacheLimiter) add(expression string, size int) []limiterEntry {
+	cl.lock.Lock()
+	defer cl.lock.Unlock()
+
+	if element, ok := cl.elements[expression]; ok {
+		entry := element.Value.(*limiterEntry)
+		cl.bytes += size - entry.size
+		entry.size = size
+		cl.order.MoveToFront(element)
+	} else {
+		cl.elements[expression] = cl.order.PushFront(&limiterEntry{expression: expression, size: size})
+		cl.bytes += size
+	}
+
+	var evicted []limiterEntry
+	for element := cl.order.Back(); element != nil && cl.overLimit(); {
+		previous := element.Prev()
+		entry := element.Value.(*limiterEntry)
+		if entry.expression != expression && !cl.keep(entry.expression) {
+			cl.order.Remove(element)
+			delete(cl.elements, entry.expression)
+			cl.bytes -= entry.size
+			evicted = append(evicted, *entry)
+		}
+		element = previous
+	}
+	return evicted
+}
+
+func (cl *cacheLimiter) overLimit() bool {
+	return (cl.maxEntries > 0 && cl.order.Len() > cl.maxEntries) || (cl.maxBytes > 0 && cl.bytes > cl.maxBytes)
+}
+
+// touch marks the expression as the most recently used.
+func (cl *cacheLimiter) touch(expression string) {
+	cl.lock.Lock()
+	if element, ok := cl.elements[expression]; ok {
+		cl.order.MoveToFront(element)
+	}
+	cl.lock.Unlock()
+}
+
+// remove stops tracking an expression that was removed from the cache.
+func (cl *cacheLimiter) remove(expression string) {
+	cl.lock.Lock()
+	if element, ok := cl.elements[expression]; ok {
+		cl.order.Remove(element)
+		delete(cl.elements, expression)
+		cl.bytes -= element.Value.(*limiterEntry).size
+	}
+	cl.lock.Unlock()
+}
+
+// resultSize approximates the memory used by a cached result, counting the
+// expression and the string data and headers of each line.
+func resultSize(expression string, lines []string) int {
+	size := len(expression) + stringHeaderSize
+	for _, line := range lines {
+		size += len(line) + stringHeaderSize
+	}
+	return size
+}
@@ -0,0 +1,27 @@
+package gorange
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestCacheLimiterKeepsWatched(t *testing.T) {
+	cl := newCacheLimiter(2, 0, func(expression string) bool { return expression == "a" })
+
+	var evicted []string
+	for _, expression := range []string{"a", "b", "c", "d"} {
+		for _, entry := range cl.add(expression, 1) {
+			evicted = append(evicted, entry.expression)
+		}
+	}
+	if want := []string{"b", "c"}; !reflect.DeepEqual(evicted, want) {
+		t.Errorf("evicted %q; want %q", evicted, want)
+	}
+
+	// When only kept expressions remain to evict, the limit is exceeded.
+	cl = newCacheLimiter(1, 0, func(string) bool { return true })
+	cl.add("a", 1)
+	if entries := cl.add("b", 1); len(entries) != 0 {
+		t.Errorf("evicted %v; want none", entries)
+	}
+}
@@ -1,34 +1,59 @@
 package gorange
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"io"
 	"strconv"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/karrick/goswarm"
 )
 
+// errClosed is returned by lookups that begin after the CachingClient is
+// closed, such as when goswarm refreshes a stale result.
+var errClosed = errors.New("cannot query closed CachingClient")
+
 type cachingClientConfig struct {
+	cacheDurations          func(string) (time.Duration, time.Duration)
+	cacheFile               string
+	cacheFilePeriodicity    time.Duration
 	client                  *Client
 	stale                   time.Duration // prune periodicity
 	expiry                  time.Duration // drop keys older than
+	honorCacheControl       bool
 	checkVersionPeriodicity time.Duration
+	maxBytes                int
+	maxEntries              int
+	negative                NegativeCachePolicy
+	warmConcurrency         int
 }
 
 // Client attempts to resolve range queries to a list of strings or an error,
 // and stores them in an in-memory cache for quick repeated lookups.
 type CachingClient struct {
+	version int64 // accessed atomically; must be first for 64-bit alignment
+
 	config cachingClientConfig
 
 	cache            *goswarm.Simple
 	lastRequestTimes *goswarm.Simple
+	limiter          *cacheLimiter // nil when cache size is not limited
+	watchers         watchers
 
-	version int64
+	// results restored from the cache file while they are loaded
+	restored     map[string]goswarm.TimedValue
+	restoredLock sync.Mutex
 
 	// handle safe shutdowns
-	closeError chan error
-	halt       chan struct{}
+	closeError  chan error
+	halt        chan struct{}
+	closing     bool           // protected by lookupsLock
+	lookups     sync.WaitGroup // lookups in progress, including stale refreshes
+	lookupsLock sync.Mutex
 }
 
 func newCachingClient(ccc cachingClientConfig) (*CachingClient, error) {
@@ -61,34 +86,78 @@ func newCachingClient(ccc cachingClientConfig) (*CachingClient, error) {
 	}
 
 	// When good config, go ahead and create instance.
-	badStaleDuration := 1 * time.Minute
-	badExpiryDuration := 5 * time.Minute
+	cc := &CachingClient{
+		closeError:       make(chan error),
+		config:           ccc,
+		halt:             make(chan struct{}),
+		lastRequestTimes: lastRequestTimes,
+	}
+	if ccc.maxEntries > 0 || ccc.maxBytes > 0 {
+		cc.limiter = newCacheLimiter(ccc.maxEntries, ccc.maxBytes, cc.watchers.watched)
+	}
 
 	expandCache, err := goswarm.NewSimple(&goswarm.Config{
 		GoodStaleDuration:  ccc.stale,
 		GoodExpiryDuration: ccc.expiry,
-		BadStaleDuration:   badStaleDuration,
-		BadExpiryDuration:  badExpiryDuration,
+		BadStaleDuration:   ccc.negative.RangeException.Stale,
+		BadExpiryDuration:  ccc.negative.RangeException.Expiry,
 		GCPeriodicity:      gcPeriodicity,
 		Lookup: func(expression string) (interface{}, error) {
-			someStrings, err := ccc.client.Query(expression)
+			if !cc.startLookup() {
+				return nil, errClosed
+			}
+			defer cc.lookups.Done()
+			if tv, ok := cc.takeRestored(expression); ok {
+				cc.limit(expression, resultSize(expression, tv.Value.(*Result).Lines))
+				return tv, nil
+			}
+			result, err := ccc.client.QueryResult(expression)
 			if err == nil {
-				return someStrings, nil
+				if result.Version == 0 {
+					result.Version = atomic.LoadInt64(&cc.version)
+				}
+				cc.limit(expression, resultSize(expression, result.Lines))
+				if cc.watchers.watched(expression) {
+					if previous, ok := cc.cache.Load(expression); ok {
+						if previous, ok := previous.(*Result); ok {
+							cc.watchers.notify(expression, previous, result)
+						}
+					}
+				}
+				if len(result.Lines) == 0 && ccc.negative.Empty.enabled() {
+					// Empty results are cached with their own durations.
+					stale, expiry := ccc.negative.Empty.times(time.Now())
+					return goswarm.TimedValue{Value: result, Stale: stale, Expiry: expiry}, nil
+				}
+				if stale, expiry, ok := ccc.cacheTimes(result, time.Now()); ok {
+					return goswarm.TimedValue{Value: result, Stale: stale, Expiry: expiry}, nil
+				}
+				return result, nil
 			}
-			if _, ok := err.(ErrRangeException); !ok {
-				// Return all non-RangeException events, including http.Get
-				// errors and ErrStatusNotOK errors, so will continue looking
-				// for a non-error value.
+			if tv := cc.cache.LoadTimedValue(expression); tv != nil && tv.Err == nil && !tv.IsExpired() {
+				// Return the error rather than caching it, so the swarm
+				// continues to serve the result it has while looking for a
+				// non-error value.
 				return nil, err
 			}
-			// ErrRangeException events are cached as bad values, so library
+			rule := ccc.negative.errorRule(err)
+			if !rule.enabled() {
+				// Store errors the policy does not cache, including http.Get
+				// errors, so they have already expired, rather than using the
+				// swarm's durations for bad values, so the next query for the
+				// expression is sent to the range servers.
+				now := time.Now()
+				return goswarm.TimedValue{Err: err, Stale: now, Expiry: now}, nil
+			}
+			// Errors the policy caches are stored as bad values, so library
 			// does not send the same request to other range servers.
-			now := time.Now()
+			cc.limit(expression, resultSize(expression, []string{err.Error()}))
+			stale, expiry := rule.times(time.Now())
 			tv := goswarm.TimedValue{
 				Value:  nil,
 				Err:    err,
-				Stale:  now.Add(badStaleDuration),
-				Expiry: now.Add(badExpiryDuration),
+				Stale:  stale,
+				Expiry: expiry,
 			}
 			return tv, nil
 		},
@@ -97,31 +166,97 @@ func newCachingClient(ccc cachingClientConfig) (*CachingClient, error) {
 		return nil, err
 	}
 
-	cc := &CachingClient{
-		cache:            expandCache,
-		closeError:       make(chan error),
-		config:           ccc,
-		halt:             make(chan struct{}),
-		lastRequestTimes: lastRequestTimes,
+	cc.cache = expandCache
+
+	if ccc.cacheFile != "" {
+		if err = cc.loadSnapshot(); err != nil {
+			_ = expandCache.Close()
+			return nil, err
+		}
 	}
 
 	go cc.run()
 	return cc, nil
 }
 
+// cacheTimes returns the stale and expiry times for a successful result
+// obtained now, and false when the configured TTL and TTE apply to it.
+func (ccc *cachingClientConfig) cacheTimes(result *Result, now time.Time) (time.Time, time.Time, bool) {
+	var stale, expiry time.Time
+
+	if ccc.cacheDurations != nil {
+		if ttl, tte := ccc.cacheDurations(result.Expression); ttl > 0 || tte > 0 {
+			if ttl > 0 {
+				stale = now.Add(ttl)
+			}
+			if tte > 0 {
+				expiry = now.Add(tte)
+			}
+			return stale, expiry, true
+		}
+	}
+
+	if ccc.honorCacheControl && result.hasMaxAge {
+		stale = now.Add(result.maxAge)
+		if ccc.expiry > 0 {
+			tte := ccc.expiry
+			if tte < result.maxAge {
+				tte = result.maxAge
+			}
+			expiry = now.Add(tte)
+		}
+		return stale, expiry, true
+	}
+
+	return stale, expiry, false
+}
+
+// startLookup returns true and counts a lookup in progress, or returns false
+// when the CachingClient is closing, and the lookup must not use the Client.
+func (cc *CachingClient) startLookup() bool {
+	cc.lookupsLock.Lock()
+	defer cc.lookupsLock.Unlock()
+	if cc.closing {
+		return false
+	}
+	cc.lookups.Add(1)
+	return true
+}
+
 // Close releases all memory and go-routines used by the Simple swarm. If during
 // instantiation, checkVersionPeriodicty was greater than the zero-value for
 // time.Duration, this method may block while completing any in progress updates
-// due to `%version` changes.
+// due to `%version` changes.  It also waits for lookups in progress, including
+// refreshes of stale results, before closing the Client they use.  When a
+// CacheFile was configured, Close writes the cache to it before returning.
 func (cc *CachingClient) Close() error {
 	close(cc.halt)
 
 	// Wait for run() loop to acknowledge signal that it's complete
 	err := <-cc.closeError
 
+	// The swarm refreshes stale results on its own go-routines, which it does
+	// not wait for, so fail lookups that start from now on, and wait for the
+	// others to complete.
+	cc.lookupsLock.Lock()
+	cc.closing = true
+	cc.lookupsLock.Unlock()
+	cc.lookups.Wait()
+
+	if cc.config.cacheFile != "" {
+		if serr := cc.saveSnapshot(); err == nil {
+			err = serr
+		}
+	}
+
 	if cerr := cc.cache.Close(); err == nil {
 		err = cerr
 	}
+	if cerr := cc.config.client.Close(); err == nil {
+		err = cerr
+	}
+
+	cc.watchers.close()
 
 	return err
 }
@@ -144,48 +279,278 @@ func (cc *CachingClient) Close() error {
 //         fmt.Println(line)
 //     }
 func (cc *CachingClient) Query(expression string) ([]string, error) {
-	cc.lastRequestTimes.Store(expression, time.Now())
-	someValue, err := cc.cache.Query(expression)
+	result, _, err := cc.cachedResult(expression)
+	if err != nil {
+		return nil, err
+	}
+	return result.Lines, nil
+}
+
+// QueryResult is like Query, but returns the lines along with their
+// provenance, including whether they were served from the cache, how long ago
+// they were obtained from a range server, and the `%version` of the range data
+// at that time.
+//
+//	result, err := querier.(gorange.ResultQuerier).QueryResult("%someQuery")
+//	if err != nil {
+//		fmt.Fprintf(os.Stderr, "ERROR: %s", err)
+//		os.Exit(1)
+//	}
+//	log.Printf("%d lines from %s (cache %s, age %s, version %d)", len(result.Lines), result.Server, result.Cache, result.Age, result.Version)
+func (cc *CachingClient) QueryResult(expression string) (*Result, error) {
+	cached, status, err := cc.cachedResult(expression)
 	if err != nil {
 		return nil, err
 	}
-	someStrings, ok := someValue.([]string)
+	result := cached.clone()
+	result.Cache = status
+	if status != CacheMiss {
+		result.Age = time.Since(result.received)
+	}
+	return result, nil
+}
+
+// QueryResultContext is like QueryResult, but returns the context's error when
+// the provided context is done before a response is available.  As with
+// QueryContext, canceling the context does not abort the lookup.
+func (cc *CachingClient) QueryResultContext(ctx context.Context, expression string) (*Result, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	type response struct {
+		result *Result
+		err    error
+	}
+	rc := make(chan response, 1) // buffered so go-routine never blocks
+
+	go func() {
+		result, err := cc.QueryResult(expression)
+		rc <- response{result, err}
+	}()
+
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case r := <-rc:
+		return r.result, r.err
+	}
+}
+
+// cachedResult returns the cached result for the expression, which must not be
+// modified, looking it up from the range servers when necessary, along with
+// whether it was satisfied by the cache.
+func (cc *CachingClient) cachedResult(expression string) (*Result, CacheStatus, error) {
+	now := time.Now()
+	cc.lastRequestTimes.Store(expression, now)
+	status := cc.cacheStatus(expression, now)
+	cc.config.client.metrics.Cache(expression, status)
+	if cc.limiter != nil {
+		cc.limiter.touch(expression)
+	}
+	someValue, err := cc.cache.Query(expression)
+	if err != nil {
+		return nil, status, err
+	}
+	result, ok := someValue.(*Result)
 	if !ok {
-		panic(fmt.Errorf("SHOULD NEVER FIND ANYTHING BUG []string in cache: %T", someValue))
+		panic(fmt.Errorf("SHOULD NEVER FIND ANYTHING BUT *Result in cache: %T", someValue))
+	}
+	return result, status, nil
+}
+
+// cacheStatus returns whether a query for the expression at the specified time
+// will be satisfied by the cache.
+func (cc *CachingClient) cacheStatus(expression string, when time.Time) CacheStatus {
+	tv := cc.cache.LoadTimedValue(expression)
+	switch {
+	case tv == nil:
+		return CacheMiss
+	case !tv.Expiry.IsZero() && when.After(tv.Expiry):
+		return CacheMiss
+	case !tv.Stale.IsZero() && when.After(tv.Stale):
+		return CacheStale
+	default:
+		return CacheHit
 	}
-	return someStrings, nil
 }
 
+// QueryContext returns the response of the query, first checking in the TTL
+// cache, then by actually sending a query to one or more of the configured
+// range servers.  When the provided context is done before a response is
+// available, it returns the context's error.  Because concurrent callers for
+// the same expression share a single lookup, canceling the context does not
+// abort that lookup; its result will still be stored in the cache for
+// subsequent queries.
+func (cc *CachingClient) QueryContext(ctx context.Context, expression string) ([]string, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	type result struct {
+		someStrings []string
+		err         error
+	}
+	rc := make(chan result, 1) // buffered so go-routine never blocks
+
+	go func() {
+		someStrings, err := cc.Query(expression)
+		rc <- result{someStrings, err}
+	}()
+
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case r := <-rc:
+		return r.someStrings, r.err
+	}
+}
+
+// Expand sends the specified expression to one or more of the configured range
+// servers, and returns the range server's compressed representation of the
+// result.  Responses from Expand are not cached.
+func (cc *CachingClient) Expand(expression string) (string, error) {
+	return cc.config.client.Expand(expression)
+}
+
+// ExpandContext is like Expand, but aborts the query and returns the context's
+// error when the provided context is done before the query completes.
+// Responses from ExpandContext are not cached.
+func (cc *CachingClient) ExpandContext(ctx context.Context, expression string) (string, error) {
+	return cc.config.client.ExpandContext(ctx, expression)
+}
+
+// Raw sends the specified expression to one or more of the configured range
+// servers, and returns the unbuffered response body for the caller to stream.
+// It is the caller's responsibility to read and Close the returned
+// io.ReadCloser.  Responses from Raw are not cached.
+func (cc *CachingClient) Raw(expression string) (io.ReadCloser, error) {
+	return cc.config.client.Raw(expression)
+}
+
+// RawContext is like Raw, but aborts the query and returns the context's error
+// when the provided context is done before the response is received.
+// Responses from RawContext are not cached.
+func (cc *CachingClient) RawContext(ctx context.Context, expression string) (io.ReadCloser, error) {
+	return cc.config.client.RawContext(ctx, expression)
+}
+
+// QueryEach sends the specified expression to one or more of the configured
+// range servers, and invokes callback for each line of the response as it is
+// read.  In order to process very large responses without buffering them in
+// memory, responses from QueryEach are not cached.
+func (cc *CachingClient) QueryEach(expression string, callback func(string) error) error {
+	return cc.config.client.QueryEach(expression, callback)
+}
+
+// QueryEachContext is like QueryEach, but aborts the query and returns the
+// context's error when the provided context is done before the response is
+// received.  Responses from QueryEachContext are not cached.
+func (cc *CachingClient) QueryEachContext(ctx context.Context, expression string, callback func(string) error) error {
+	return cc.config.client.QueryEachContext(ctx, expression, callback)
+}
+
+// ServerStatuses returns the health of each configured range server, or nil
+// when circuit breaking is not enabled by the CircuitBreakerThreshold
+// configuration option.
+func (cc *CachingClient) ServerStatuses() []ServerStatus {
+	return cc.config.client.ServerStatuses()
+}
+
+// SetServers replaces the range servers to which queries are sent.  Cached
+// results are kept, and are refreshed from the new range servers.
+func (cc *CachingClient) SetServers(servers []string) error {
+	return cc.config.client.SetServers(servers)
+}
+
+// SetSelector replaces the Selector that chooses the range servers to which
+// queries are sent.  Cached results are kept, and are refreshed from the new
+// range servers.
+func (cc *CachingClient) SetSelector(selector Selector) error {
+	return cc.config.client.SetSelector(selector)
+}
+
+// Verify sends the specified expression to several range servers and compares
+// their responses.  Responses from Verify are not cached.
+func (cc *CachingClient) Verify(expression string) (*Verification, error) {
+	return cc.config.client.Verify(expression)
+}
+
+// VerifyContext is like Verify, but aborts the queries and returns the
+// context's error when the provided context is done before they complete.
+func (cc *CachingClient) VerifyContext(ctx context.Context, expression string) (*Verification, error) {
+	return cc.config.client.VerifyContext(ctx, expression)
+}
+
+// limit records the size of a value about to be stored in the cache, and
+// evicts the least recently used expressions when the cache exceeds its
+// configured limits.
+func (cc *CachingClient) limit(expression string, size int) {
+	if cc.limiter == nil {
+		return
+	}
+	for _, entry := range cc.limiter.add(expression, size) {
+		cc.cache.Delete(entry.expression)
+		cc.lastRequestTimes.Delete(entry.expression)
+		cc.config.client.metrics.Evict(entry.expression, entry.size)
+	}
+}
+
+// forget removes the expression from the cache.
+func (cc *CachingClient) forget(expression string) {
+	cc.cache.Delete(expression)
+	cc.lastRequestTimes.Delete(expression)
+	if cc.limiter != nil {
+		cc.limiter.remove(expression)
+	}
+}
+
+// lastRequestTime returns when the expression was last requested, or the zero
+// time when that is unknown, such as when the expression was forgotten while a
+// query for it was in flight.
 func (cc *CachingClient) lastRequestTime(key string) time.Time {
-	lrt, ok := cc.lastRequestTimes.Load(key)
-	if !ok {
-		panic(fmt.Errorf("SHOULD NEVER FIND KEY IN cache BUT NOT IN lastRequestTimes: %q", key))
+	if lrt, ok := cc.lastRequestTimes.Load(key); ok {
+		if when, ok := lrt.(time.Time); ok {
+			return when
+		}
 	}
-	return lrt.(time.Time)
+	return time.Time{}
 }
 
 func (cc *CachingClient) refreshBasedOnVersion() error {
 	someStrings, err := cc.config.client.Query("%version")
 	if err != nil {
+		cc.config.client.metrics.VersionCheck(0, false, err)
 		return err
 	}
 	if len(someStrings) != 1 {
-		return fmt.Errorf("%%version returned %d output lines; expected 1 line", len(someStrings))
+		err = fmt.Errorf("%%version returned %d output lines; expected 1 line", len(someStrings))
+		cc.config.client.metrics.VersionCheck(0, false, err)
+		return err
 	}
 	// version is an epoch timestamp
 	version, err := strconv.ParseInt(someStrings[0], 10, 64)
 	if err != nil {
+		cc.config.client.metrics.VersionCheck(0, false, err)
 		return err
 	}
-	if version > cc.version {
+	changed := version > cc.version
+	cc.config.client.metrics.VersionCheck(version, changed, nil)
+	if changed {
+		// Update the version first, so refreshed results record the new one.
+		atomic.StoreInt64(&cc.version, version)
 		cutoff := time.Unix(version, 0).Add(-cc.config.stale)
-		cc.refreshBefore(cutoff)
-		cc.version = version
+		cc.refreshBefore(cutoff, false)
 	}
 	return nil
 }
 
-func (cc *CachingClient) refreshBefore(cutoff time.Time) {
+// refreshBefore forgets cached errors, and results for expressions that are not
+// watched and were last requested before cutoff, and refreshes the remaining
+// results.  When staleOnly is true, results that are not yet stale are left
+// alone, so a result whose TTL is longer than the configured TTL, such as from
+// CacheDurations or Cache-Control, is not refreshed early.
+func (cc *CachingClient) refreshBefore(cutoff time.Time, staleOnly bool) {
 	// To prevent overloading the range server with refresh requests for lots of
 	// keys at once, trickle them in one-by-one.
 	toRefresh := make(chan string, 64) // WARNING: must be at least 1 to prevent Range callback from dead locking
@@ -199,50 +564,74 @@ func (cc *CachingClient) refreshBefore(cutoff time.Time) {
 		}
 	}()
 
+	var refreshed, dropped int
+
 	// Go maps and goswarm.Simple's Range method allows deleting keys while iterating over the
 	// map's key-value pairs.  We'll use that to our advantage below.
 	cc.cache.Range(func(key string, tv *goswarm.TimedValue) {
 		if tv.Err != nil {
 			// log.Printf("deleting result that is an error: %q", key)
-			cc.cache.Delete(key)
-		} else if cc.lastRequestTime(key).Before(cutoff) {
+			cc.forget(key)
+			dropped++
+		} else if cc.lastRequestTime(key).Before(cutoff) && !cc.watchers.watched(key) {
 			// log.Printf("dropping because last requested quite a while ago: %q", key)
-			cc.cache.Delete(key)
-		} else {
+			cc.forget(key)
+			dropped++
+		} else if !staleOnly || tv.IsStale() {
 			// log.Printf("enqueue request to update: %q", key)
 			toRefresh <- key
+			refreshed++
 		}
 	})
 	close(toRefresh)
 	refresher.Wait()
+
+	// Also forget when expressions were requested after the cache drops them
+	// for other reasons, such as expiring.  Recently requested expressions
+	// are kept, because they may be in the process of being looked up.
+	cc.lastRequestTimes.Range(func(key string, tv *goswarm.TimedValue) {
+		if when, ok := tv.Value.(time.Time); ok && when.Before(cutoff) && cc.cache.LoadTimedValue(key) == nil {
+			cc.lastRequestTimes.Delete(key)
+		}
+	})
+
+	cc.config.client.metrics.Refresh(refreshed, dropped)
 }
 
 func (cc *CachingClient) run() {
-	// If param is 0, client does not want to use the feature, so make it a very
-	// long periodicity, and when the select case is chosen, skip calling the
-	// feature.
-	checkVersionPeriodicity := cc.config.checkVersionPeriodicity
-	if checkVersionPeriodicity == 0 {
-		// long enough that we do not care about cycles that do a no-op
-		checkVersionPeriodicity = 24 * time.Hour
+	// Each feature uses its own ticker, so a feature with a short periodicity
+	// does not delay the others.  A nil channel is never selected, so a
+	// feature the client does not want to use is never invoked.
+	var tickers []*time.Ticker
+	defer func() {
+		for _, ticker := range tickers {
+			ticker.Stop()
+		}
+	}()
+	tick := func(periodicity time.Duration) <-chan time.Time {
+		if periodicity <= 0 {
+			return nil
+		}
+		ticker := time.NewTicker(periodicity)
+		tickers = append(tickers, ticker)
+		return ticker.C
 	}
-	stale := cc.config.stale
-	if stale == 0 {
-		// long enough that we do not care about cycles that do a no-op
-		stale = 24 * time.Hour
+	versions := tick(cc.config.checkVersionPeriodicity)
+	refreshes := tick(cc.config.stale)
+	var snapshots <-chan time.Time
+	if cc.config.cacheFile != "" {
+		snapshots = tick(cc.config.cacheFilePeriodicity)
 	}
 
 	for {
 		select {
-		case <-time.After(checkVersionPeriodicity):
-			if cc.config.checkVersionPeriodicity > 0 {
-				_ = cc.refreshBasedOnVersion() // ignoring error return value
-			}
-		case <-time.After(stale):
-			if cc.config.stale > 0 {
-				cutoff := time.Now().Add(-cc.config.expiry)
-				cc.refreshBefore(cutoff)
-			}
+		case <-snapshots:
+			_ = cc.saveSnapshot() // ignoring error return value
+		case <-versions:
+			_ = cc.refreshBasedOnVersion() // ignoring error return value
+		case <-refreshes:
+			cutoff := time.Now().Add(-cc.config.expiry)
+			cc.refreshBefore(cutoff, true)
 		case <-cc.halt:
 			cc.closeError <- nil
 			// there is no cleanup required, so we just return
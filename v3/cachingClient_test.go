@@ -0,0 +1,158 @@
+package gorange
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestCachingClientCloseWaitsForStaleRefresh(t *testing.T) {
+	var served int64 // accessed atomically
+	server := newTestRangeServer(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-time.After(20 * time.Millisecond): // still responding when Close is called
+			atomic.AddInt64(&served, 1)
+			fmt.Fprintln(w, r.URL.RawQuery)
+		case <-r.Context().Done():
+		}
+	})
+	defer server.Close()
+
+	for i := int64(0); i < 10; i++ {
+		querier, err := NewQuerier(&Configurator{
+			Servers:                 []string{server.URL},
+			CircuitBreakerThreshold: 3,
+			TTL:                     time.Hour,
+			CacheDurations: func(string) (time.Duration, time.Duration) {
+				return 5 * time.Millisecond, time.Hour
+			},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		cc := querier.(*CachingClient)
+
+		if _, err = cc.Query("web1"); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(10 * time.Millisecond)
+		if _, err = cc.Query("web1"); err != nil { // stale, so refreshed in background
+			t.Fatal(err)
+		}
+		waitFor(t, "stale refresh", func() bool { return server.count() == 2*(i+1) })
+
+		if err = cc.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if got, want := atomic.LoadInt64(&served), server.count(); got != want {
+			t.Fatalf("Close aborted stale refresh: served %d of %d requests", got, want)
+		}
+	}
+}
+
+func TestCachingClientKeepsResultOnCachedError(t *testing.T) {
+	var failing int32 // accessed atomically
+	server := newTestRangeServer(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.LoadInt32(&failing) == 1 {
+			http.Error(w, "try again later", http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprintln(w, r.URL.RawQuery)
+	})
+	defer server.Close()
+
+	querier, err := NewQuerier(&Configurator{
+		Servers: []string{server.URL},
+		NegativeCachePolicy: &NegativeCachePolicy{
+			StatusNotOK: NegativeCacheRule{Expiry: time.Hour},
+		},
+		TTL: 5 * time.Millisecond,
+		TTE: time.Hour,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = querier.Close() }()
+
+	if _, err = querier.Query("web1"); err != nil {
+		t.Fatal(err)
+	}
+	atomic.StoreInt32(&failing, 1)
+
+	for i := int64(0); i < 3; i++ {
+		time.Sleep(10 * time.Millisecond)
+		lines, err := querier.Query("web1") // stale, so refreshed in background
+		if err != nil {
+			t.Fatalf("query %d: %s", i, err)
+		}
+		if len(lines) != 1 || lines[0] != "web1" {
+			t.Fatalf("query %d: lines = %q; want web1", i, lines)
+		}
+		waitFor(t, "stale refresh", func() bool { return server.count() >= i+2 })
+	}
+}
+
+func TestCachingClientRefreshHonorsCacheDurations(t *testing.T) {
+	var lock sync.Mutex
+	requests := make(map[string]int)
+	server := newTestRangeServer(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		requests[r.URL.RawQuery]++
+		lock.Unlock()
+		fmt.Fprintln(w, r.URL.RawQuery)
+	})
+	defer server.Close()
+
+	querier, err := NewQuerier(&Configurator{
+		Servers: []string{server.URL},
+		TTL:     10 * time.Millisecond,
+		TTE:     time.Hour,
+		CacheDurations: func(expression string) (time.Duration, time.Duration) {
+			if expression == "slow" {
+				return time.Hour, 2 * time.Hour
+			}
+			return 0, 0
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = querier.Close() }()
+
+	for _, expression := range []string{"slow", "fast"} {
+		if _, err = querier.Query(expression); err != nil {
+			t.Fatal(err)
+		}
+	}
+	waitFor(t, "refreshes", func() bool {
+		lock.Lock()
+		defer lock.Unlock()
+		return requests["fast"] >= 3
+	})
+
+	lock.Lock()
+	defer lock.Unlock()
+	if got := requests["slow"]; got != 1 {
+		t.Errorf("expression with 1h TTL fetched %d times; want 1", got)
+	}
+}
+
+func TestCacheDurationsRequiresCaching(t *testing.T) {
+	_, err := NewQuerier(&Configurator{
+		Servers:        []string{"127.0.0.1:1"},
+		CacheDurations: func(string) (time.Duration, time.Duration) { return time.Minute, time.Hour },
+	})
+	if err == nil {
+		t.Error("NewQuerier accepted CacheDurations without TTL, TTE, or CheckVersionPeriodicity")
+	}
+	_, err = NewQuerier(&Configurator{
+		Servers:           []string{"127.0.0.1:1"},
+		HonorCacheControl: true,
+	})
+	if err == nil {
+		t.Error("NewQuerier accepted HonorCacheControl without TTL, TTE, or CheckVersionPeriodicity")
+	}
+}
@@ -2,12 +2,19 @@ package gorange
 
 import (
 	"bufio"
+	"context"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"net/http"
 	"net/url"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
+
+	"github.com/karrick/gorange/v3/expr"
 )
 
 // defaultQueryLengthThreshold defines the maximum length of the URI for an
@@ -15,23 +22,63 @@ import (
 // sent out via a PUT query.
 const defaultQueryLengthThreshold = 4096
 
+// Range server API paths, appended to each server endpoint.
+const (
+	expandPath = "/range/expand"
+	listPath   = "/range/list"
+)
+
 // Client attempts to resolve range queries to a list of strings or an error.
+// Concurrent queries for the same expression are coalesced into a single
+// request to the range servers.
 type Client struct {
+	flights       flightGroup
+	expandLocally bool
+	health        *healthTracker
+	hedgeBudget   *retryBudget
+	hedgeDelay    time.Duration
+	hedges        sync.WaitGroup // hedged requests still running
+	maxHedges     int
 	httpClient    *http.Client
-	servers       *roundRobinStrings
+	maxLineLength int
+	maxResults    int
+	metrics       Metrics
 	retryCallback func(error) bool
+	retryBudget   *retryBudget
 	retryCount    int
-	retryPause    time.Duration
+	retryPolicy   RetryPolicy
+	resolver      *serverResolver
+	servers       *serverSet
+	serversLock   sync.RWMutex
+	verifyCount   int
+	versions      *versionTracker
 }
 
-// Close cleans up resources held by Client.  Calling Query method after Close
-// will result in a panic.
+// Close cleans up resources held by Client.  Queries still in flight are
+// canceled, and Close waits for them to return.  Calling Query method after
+// Close will result in a panic.
 func (c *Client) Close() error {
-	c.httpClient = nil
+	if c.resolver != nil {
+		c.resolver.close()
+		c.resolver = nil
+	}
+	// Shared queries run on their own go-routines, even after every caller has
+	// given up on them, so wait for them before releasing what they use.
+	c.flights.close()
+	// Hedged requests that lost the race are canceled, but may still be
+	// returning.
+	c.hedges.Wait()
+	if c.health != nil {
+		c.health.close()
+		c.health = nil
+	}
+	if c.versions != nil {
+		c.versions.close()
+		c.versions = nil
+	}
+	c.serversLock.Lock()
 	c.servers = nil
-	c.retryCallback = nil
-	c.retryCount = 0
-	c.retryPause = 0
+	c.serversLock.Unlock()
 	return nil
 }
 
@@ -52,61 +99,472 @@ func (c *Client) Close() error {
 //         fmt.Println(line)
 //     }
 func (c *Client) Query(expression string) ([]string, error) {
-	iorc, err := c.getFromRangeServers(expression)
+	return c.QueryContext(context.Background(), expression)
+}
+
+// QueryContext sends the specified expression to one or more of the configured
+// range servers, and converts a non-error result into a list of strings.  When
+// the provided context is canceled or its deadline expires, any in-flight
+// request is aborted, no further retries are attempted, and the context's error
+// is returned.
+//
+//	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+//	defer cancel()
+//	lines, err := querier.QueryContext(ctx, "%someQuery")
+//	if err != nil {
+//		fmt.Fprintf(os.Stderr, "ERROR: %s", err)
+//		os.Exit(1)
+//	}
+//	for _, line := range lines {
+//		fmt.Println(line)
+//	}
+func (c *Client) QueryContext(ctx context.Context, expression string) ([]string, error) {
+	result, err := c.QueryResultContext(ctx, expression)
+	if err != nil {
+		return nil, err
+	}
+	return result.Lines, nil
+}
+
+// QueryResult is like Query, but returns the lines along with their
+// provenance, such as which range server provided them, which HTTP method was
+// used, and how many attempts were required.
+//
+//	result, err := querier.(gorange.ResultQuerier).QueryResult("%someQuery")
+//	if err != nil {
+//		fmt.Fprintf(os.Stderr, "ERROR: %s", err)
+//		os.Exit(1)
+//	}
+//	log.Printf("%d lines from %s using %s", len(result.Lines), result.Server, result.Method)
+func (c *Client) QueryResult(expression string) (*Result, error) {
+	return c.QueryResultContext(context.Background(), expression)
+}
+
+// QueryResultContext is like QueryResult, but aborts the query and returns the
+// context's error when the provided context is done before the query
+// completes.
+func (c *Client) QueryResultContext(ctx context.Context, expression string) (*Result, error) {
+	if c.expandLocally {
+		start := time.Now()
+		if lines, ok := expandLocally(expression); ok {
+			if c.maxResults > 0 && len(lines) > c.maxResults {
+				return nil, ErrTooManyResults{Limit: c.maxResults}
+			}
+			return &Result{
+				Expression: expression,
+				Lines:      lines,
+				Latency:    time.Since(start),
+				received:   time.Now(),
+			}, nil
+		}
+	}
+	return c.flights.do(ctx, expression, c.query)
+}
+
+// Coalesced returns the number of Query and QueryContext calls that did not
+// send their own request to the range servers, but instead shared the response
+// of a concurrent call for the same expression.
+func (c *Client) Coalesced() uint64 {
+	return atomic.LoadUint64(&c.flights.coalesced)
+}
+
+// query sends the expression to the range servers and buffers the response.
+func (c *Client) query(ctx context.Context, expression string) (*Result, error) {
+	start := time.Now()
+	resp, err := c.getFromRangeServers(ctx, listPath, expression)
 	if err != nil {
 		return nil, err
 	}
 
 	var lines []string
+	err = c.scanLines(resp, func(line string) error {
+		lines = append(lines, line)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	version := resp.version
+	if version == 0 && c.versions != nil {
+		version = c.versions.version(resp.server)
+	}
+
+	now := time.Now()
+	return &Result{
+		Expression: expression,
+		Lines:      lines,
+		Server:     resp.server,
+		Method:     resp.method,
+		Attempts:   resp.attempts,
+		Latency:    now.Sub(start),
+		Version:    version,
+		received:   now,
+		maxAge:     resp.maxAge,
+		hasMaxAge:  resp.hasMaxAge,
+	}, nil
+}
+
+// QueryEach sends the specified expression to one or more of the configured
+// range servers, and invokes callback for each line of the response as it is
+// read, without buffering the entire response in memory.  The response is read
+// no faster than callback returns.  When callback returns an error, QueryEach
+// stops reading the response and returns that error.
+//
+// If the response includes a RangeException header, it returns
+// ErrRangeException.  If the status code is not okay, it returns
+// ErrStatusNotOK.  If the response has more lines than the configured
+// MaxResults, it returns ErrTooManyResults.  Finally, if it cannot parse the
+// lines in the response body, including when a line is longer than the
+// configured MaxLineLength, it returns ErrParseException.
+//
+//	err := querier.(gorange.EachQuerier).QueryEach("%someQuery", func(line string) error {
+//		fmt.Println(line)
+//		return nil
+//	})
+//	if err != nil {
+//		fmt.Fprintf(os.Stderr, "ERROR: %s", err)
+//		os.Exit(1)
+//	}
+func (c *Client) QueryEach(expression string, callback func(string) error) error {
+	return c.QueryEachContext(context.Background(), expression, callback)
+}
+
+// QueryEachContext is like QueryEach, but aborts the query and returns the
+// context's error when the provided context is done before the response is
+// received.  Canceling the context while the response is being read causes
+// QueryEachContext to return an error.
+func (c *Client) QueryEachContext(ctx context.Context, expression string, callback func(string) error) error {
+	if c.expandLocally {
+		if lines, ok := expandLocally(expression); ok {
+			if c.maxResults > 0 && len(lines) > c.maxResults {
+				return ErrTooManyResults{Limit: c.maxResults}
+			}
+			for _, line := range lines {
+				if err := callback(line); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+	}
+
+	iorc, err := c.getFromRangeServers(ctx, listPath, expression)
+	if err != nil {
+		return err
+	}
+	return c.scanLines(iorc, callback)
+}
+
+// scanLines invokes callback for each line read from iorc, honoring the
+// configured maximum line length and maximum number of results, then closes
+// iorc.
+func (c *Client) scanLines(iorc io.ReadCloser, callback func(string) error) error {
 	scanner := bufio.NewScanner(iorc)
+	if c.maxLineLength > 0 {
+		// Buffer must also hold the line terminator, which may be "\r\n", so
+		// the length of each line is checked below.
+		scanner.Buffer(nil, c.maxLineLength+2)
+	}
+
+	var count int
+	var err error
 
 	for scanner.Scan() {
-		lines = append(lines, scanner.Text())
+		if c.maxLineLength > 0 && len(scanner.Bytes()) > c.maxLineLength {
+			err = ErrParseException{Err: bufio.ErrTooLong}
+			break
+		}
+		if c.maxResults > 0 && count == c.maxResults {
+			err = ErrTooManyResults{Limit: c.maxResults}
+			break
+		}
+		count++
+		if err = callback(scanner.Text()); err != nil {
+			break
+		}
+	}
+
+	if err == nil {
+		if serr := scanner.Err(); serr != nil { // always check for scan error
+			err = ErrParseException{Err: serr}
+		}
+	}
+
+	// always close the stream, but scan error has more context than close error
+	if cerr := iorc.Close(); err == nil && cerr != nil {
+		err = ErrParseException{Err: cerr}
+	}
+	return err
+}
+
+// Expand sends the specified expression to one or more of the configured range
+// servers, and returns the range server's compressed representation of the
+// result, as provided by its `/range/expand` endpoint.
+//
+// If the response includes a RangeException header, it returns
+// ErrRangeException.  If the status code is not okay, it returns
+// ErrStatusNotOK.  Finally, if it cannot read the response body, it returns
+// ErrParseException.
+//
+//	result, err := querier.(gorange.Expander).Expand("%someQuery")
+//	if err != nil {
+//		fmt.Fprintf(os.Stderr, "ERROR: %s", err)
+//		os.Exit(1)
+//	}
+//	fmt.Println(result)
+func (c *Client) Expand(expression string) (string, error) {
+	return c.ExpandContext(context.Background(), expression)
+}
+
+// ExpandContext is like Expand, but aborts the query and returns the context's
+// error when the provided context is done before the query completes.
+func (c *Client) ExpandContext(ctx context.Context, expression string) (string, error) {
+	iorc, err := c.getFromRangeServers(ctx, expandPath, expression)
+	if err != nil {
+		return "", err
 	}
 
-	err = scanner.Err()  // always check for scan error
-	cerr := iorc.Close() // always close the stream
+	buf, err := ioutil.ReadAll(iorc) // always read entire body
+	cerr := iorc.Close()             // always close the stream
 
-	// scan error has more context than close error
+	// read error has more context than close error
 	if err != nil {
-		return nil, ErrParseException{Err: err}
+		return "", ErrParseException{Err: err}
 	}
 	if cerr != nil {
-		return nil, ErrParseException{Err: cerr}
+		return "", ErrParseException{Err: cerr}
 	}
-	return lines, nil
+	return string(buf), nil
+}
+
+// Raw sends the specified expression to one or more of the configured range
+// servers, and returns the body of the `/range/list` response for the caller to
+// stream, rather than buffering the entire response in memory.  It is the
+// caller's responsibility to read and Close the returned io.ReadCloser.
+//
+// If the response includes a RangeException header, it returns
+// ErrRangeException.  If the status code is not okay, it returns
+// ErrStatusNotOK.
+//
+//	iorc, err := querier.(gorange.RawQuerier).Raw("%someQuery")
+//	if err != nil {
+//		fmt.Fprintf(os.Stderr, "ERROR: %s", err)
+//		os.Exit(1)
+//	}
+//	_, err = io.Copy(os.Stdout, iorc)
+//	if cerr := iorc.Close(); err == nil {
+//		err = cerr
+//	}
+func (c *Client) Raw(expression string) (io.ReadCloser, error) {
+	return c.RawContext(context.Background(), expression)
+}
+
+// RawContext is like Raw, but aborts the query and returns the context's error
+// when the provided context is done before the response is received.  Because
+// the returned io.ReadCloser streams the response body, canceling the context
+// before the body has been consumed will cause subsequent reads to fail.
+func (c *Client) RawContext(ctx context.Context, expression string) (io.ReadCloser, error) {
+	resp, err := c.getFromRangeServers(ctx, listPath, expression)
+	if err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// expandLocally returns the expansion of a self-contained expression and true,
+// or false when the expression must be sent to a range server.  Expressions that
+// fail to parse or expand locally are left for the range server to evaluate, so
+// the caller receives the server's own error message.
+func expandLocally(expression string) ([]string, bool) {
+	node, err := expr.Parse(expression)
+	if err != nil || !expr.IsLocal(node) {
+		return nil, false
+	}
+	lines, err := expr.Expand(node)
+	if err != nil {
+		return nil, false
+	}
+	return lines, true
+}
+
+// serverResponse is the body of a successful range server response, along with how
+// it was obtained.
+type serverResponse struct {
+	io.ReadCloser
+	server    string        // range server address
+	method    string        // HTTP method of the successful request
+	attempts  int           // number of attempts, including retries
+	maxAge    time.Duration // from the Cache-Control header
+	hasMaxAge bool          // true when the Cache-Control header has max-age
+	version   int64         // `%version` checked before the query, or 0
 }
 
 // getFromRangeServers iterates through the round robin list of servers, sending
 // query to each server, one after the other, until a non-error result is
-// obtained. It returns an io.ReadCloser for reading the HTTP response body, or
-// an error when all the servers return an error for that query.
-func (c *Client) getFromRangeServers(expression string) (io.ReadCloser, error) {
+// obtained. It returns a serverResponse for reading the HTTP response body, or an
+// error when all the servers return an error for that query.  It stops retrying
+// and returns the context error as soon as ctx is done.
+func (c *Client) getFromRangeServers(ctx context.Context, path, expression string) (resp *serverResponse, err error) {
+	start := time.Now()
+	defer func() { c.metrics.Query(expression, time.Since(start), err) }()
+
+	if c.retryBudget != nil {
+		c.retryBudget.deposit()
+	}
+
+	if c.retryPolicy.Deadline > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.retryPolicy.Deadline)
+		defer func() {
+			if err != nil {
+				cancel()
+				return
+			}
+			resp.ReadCloser = cancelReadCloser{ReadCloser: resp.ReadCloser, cancel: cancel}
+		}()
+	}
+
 	var attempts int
+	var pause time.Duration
 	for {
-		iorc, err := c.getFromRangeServer(expression)
-		if err == nil || attempts == c.retryCount || c.retryCallback(err) == false {
-			return iorc, err
+		if c.hedgeDelay > 0 {
+			resp, err = c.getFromRangeServerHedged(ctx, path, expression)
+		} else {
+			resp, err = c.getFromRangeServer(ctx, path, expression)
+		}
+		if err == nil {
+			resp.attempts = attempts + 1
+			return resp, nil
+		}
+		if cerr := ctx.Err(); cerr != nil {
+			return nil, cerr
+		}
+		if attempts == c.retryCount || c.retryCallback(err) == false {
+			return nil, err
 		}
 		attempts++
-		if c.retryPause > 0 {
-			time.Sleep(c.retryPause)
+		pause = c.retryPolicy.backoff(attempts, pause)
+		if deadline, ok := ctx.Deadline(); ok && time.Now().Add(pause).After(deadline) {
+			return nil, err // retry could not complete before the deadline
+		}
+		if c.retryBudget != nil && !c.retryBudget.withdraw() {
+			return nil, err // retry budget exhausted
+		}
+		c.metrics.Retry(expression, attempts, err)
+		if pause > 0 {
+			timer := time.NewTimer(pause)
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return nil, ctx.Err()
+			case <-timer.C:
+			}
+		}
+	}
+}
+
+// getFromRangeServer selects the next range server, sends it the query, and
+// records whether the server responded successfully.
+func (c *Client) getFromRangeServer(ctx context.Context, path, expression string) (*serverResponse, error) {
+	set := c.serverSet() // remains consistent even when servers are replaced
+	server := set.selector.Next(c.available)
+
+	var version int64
+	if c.versions != nil {
+		var err error
+		if server, version, err = c.currentServer(ctx, set, server); err != nil {
+			return nil, err
 		}
 	}
+
+	endpoint, err := set.endpoint(server)
+	if err != nil {
+		return nil, err
+	}
+
+	start := time.Now()
+	resp, err := c.getFromServer(ctx, endpoint, path, expression)
+	if err == nil {
+		resp.server = server
+		resp.version = version
+	}
+
+	if ctx.Err() == nil {
+		// Only blame the range server when the request failed because of the
+		// server or the network, rather than because of the query.
+		serr := err
+		if serr != nil && !isServerFailure(serr) {
+			serr = nil
+		}
+		set.selector.Observe(server, time.Since(start), serr)
+		if c.health != nil {
+			if serr != nil {
+				c.health.failure(server, serr)
+			} else {
+				c.health.success(server)
+			}
+		}
+	}
+	return resp, err
+}
+
+// available returns true when the range server's circuit is closed, or when
+// circuit breaking is not enabled, and when the range server's data is not
+// older than data already observed, or when monotonic reads are not enabled.
+func (c *Client) available(server string) bool {
+	return (c.health == nil || c.health.available(server)) &&
+		(c.versions == nil || c.versions.current(server))
+}
+
+// endpoint returns the URL prefix for the range server address.
+func (c *Client) endpoint(server string) (string, error) {
+	return c.serverSet().endpoint(server)
+}
+
+// probeServer sends the `%version` query to the specified range server, to
+// determine whether an ejected server has recovered.
+func (c *Client) probeServer(server string) error {
+	endpoint, err := c.endpoint(server)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), DefaultQueryTimeout)
+	defer cancel()
+	resp, err := c.getFromServer(ctx, endpoint, listPath, "%version")
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(ioutil.Discard, resp)
+	if cerr := resp.Close(); err == nil {
+		err = cerr
+	}
+	return err
 }
 
-// getFromRangeServer sends to server the query and returns either a
-// io.ReadCloser for reading the valid server response, or an error. This
-// function attempts to send the query using both GET and PUT HTTP methods. It
-// defaults to using GET first, then trying PUT, unless the query length is
-// longer than a program constant, in which case it first tries PUT then will
-// try GET.
-func (c *Client) getFromRangeServer(expression string) (io.ReadCloser, error) {
+// ServerStatuses returns the health of each configured range server, or nil
+// when circuit breaking is not enabled by the CircuitBreakerThreshold
+// configuration option.
+func (c *Client) ServerStatuses() []ServerStatus {
+	if c.health == nil {
+		return nil
+	}
+	return c.health.statuses()
+}
+
+// getFromServer sends to the range server endpoint the query and returns either a response
+// for reading the valid server response, or an error. This function attempts
+// to send the query using both GET and PUT HTTP methods. It defaults to using
+// GET first, then trying PUT, unless the query length is longer than a program
+// constant, in which case it first tries PUT then will try GET.  The provided
+// context is attached to each outgoing request, and path selects which range
+// server API endpoint receives the query.
+func (c *Client) getFromServer(ctx context.Context, server, path, expression string) (*serverResponse, error) {
 	var err, herr error
 	var response *http.Response
 
 	// need endpoint for both GET and PUT, so keep it separate
-	endpoint := fmt.Sprintf("http://%s/range/list", c.servers.Next())
+	endpoint := server + path
 
 	// need uri for just GET
 	uri := fmt.Sprintf("%s?%s", endpoint, url.QueryEscape(expression))
@@ -122,15 +580,20 @@ func (c *Client) getFromRangeServer(expression string) (io.ReadCloser, error) {
 
 	// At least 2 tries so we can try GET or POST if server gives us 405 or 414.
 	for triesRemaining := 2; triesRemaining > 0; triesRemaining-- {
+		if err = ctx.Err(); err != nil {
+			return nil, err
+		}
+		start := time.Now()
 		switch method {
 		case http.MethodGet:
-			response, err = c.httpClient.Get(uri)
+			response, err = c.getQuery(ctx, uri)
 		case http.MethodPut:
-			response, err = c.putQuery(endpoint, expression)
+			response, err = c.putQuery(ctx, endpoint, expression)
 		default:
 			panic(fmt.Errorf("cannot use unsupported HTTP method: %q", method))
 		}
 		if err != nil {
+			c.metrics.Request(server, method, time.Since(start), 0, err)
 			return nil, err // could not even make network request
 		}
 
@@ -140,40 +603,80 @@ func (c *Client) getFromRangeServer(expression string) (io.ReadCloser, error) {
 		switch response.StatusCode {
 		case http.StatusOK:
 			if message := response.Header.Get("RangeException"); message != "" {
-				return nil, ErrRangeException{Message: message}
+				herr = ErrRangeException{Message: message}
+				c.metrics.Request(server, method, time.Since(start), response.StatusCode, herr)
+				c.metrics.RangeException(server, message)
+				return nil, herr
 			}
-			return response.Body, nil // range server provided non-error response
+			c.metrics.Request(server, method, time.Since(start), response.StatusCode, nil)
+			resp := &serverResponse{ReadCloser: response.Body, method: method}
+			resp.maxAge, resp.hasMaxAge = cacheMaxAge(response.Header)
+			return resp, nil // range server provided non-error response
 		case http.StatusRequestURITooLong:
-			method = http.MethodPut // try again using PUT
 			herr = ErrStatusNotOK{
 				Status:     response.Status,
 				StatusCode: response.StatusCode,
 			}
+			c.metrics.Request(server, method, time.Since(start), response.StatusCode, herr)
+			if triesRemaining > 1 {
+				c.metrics.MethodFallback(server, method, http.MethodPut)
+			}
+			method = http.MethodPut // try again using PUT
 		case http.StatusMethodNotAllowed:
-			method = http.MethodGet // try again using GET
 			herr = ErrStatusNotOK{
 				Status:     response.Status,
 				StatusCode: response.StatusCode,
 			}
+			c.metrics.Request(server, method, time.Since(start), response.StatusCode, herr)
+			if triesRemaining > 1 {
+				c.metrics.MethodFallback(server, method, http.MethodGet)
+			}
+			method = http.MethodGet // try again using GET
 		default:
 			herr = ErrStatusNotOK{
 				Status:     response.Status,
 				StatusCode: response.StatusCode,
 			}
+			c.metrics.Request(server, method, time.Since(start), response.StatusCode, herr)
 		}
 	}
 
 	return nil, herr
 }
 
-func (c *Client) putQuery(endpoint, expression string) (*http.Response, error) {
+// cacheMaxAge returns the max-age directive from the Cache-Control header, and
+// false when the header has no valid max-age directive.
+func cacheMaxAge(header http.Header) (time.Duration, bool) {
+	for _, directive := range strings.Split(header.Get("Cache-Control"), ",") {
+		directive = strings.TrimSpace(directive)
+		if len(directive) < 8 || !strings.EqualFold(directive[:8], "max-age=") {
+			continue
+		}
+		seconds, err := strconv.ParseUint(strings.Trim(directive[8:], `"`), 10, 32)
+		if err != nil {
+			return 0, false
+		}
+		return time.Duration(seconds) * time.Second, true
+	}
+	return 0, false
+}
+
+func (c *Client) getQuery(ctx context.Context, uri string) (*http.Response, error) {
+	request, err := http.NewRequest(http.MethodGet, uri, nil)
+	if err != nil {
+		return nil, err
+	}
+	return c.httpClient.Do(request.WithContext(ctx))
+}
+
+func (c *Client) putQuery(ctx context.Context, endpoint, expression string) (*http.Response, error) {
 	form := url.Values{"query": []string{expression}}
 	request, err := http.NewRequest(http.MethodPut, endpoint, strings.NewReader(form.Encode()))
 	if err != nil {
 		return nil, err
 	}
 	request.Header.Add("Content-Type", "application/x-www-form-urlencoded")
-	return c.httpClient.Do(request)
+	return c.httpClient.Do(request.WithContext(ctx))
 }
 
 // ErrRangeException is returned when the response headers includes
@@ -196,6 +699,26 @@ func (err ErrStatusNotOK) Error() string {
 	return err.Status
 }
 
+// ErrTooManyResults is returned when a query response has more lines than the
+// configured MaxResults.
+type ErrTooManyResults struct {
+	Limit int
+}
+
+func (err ErrTooManyResults) Error() string {
+	return fmt.Sprintf("query response has more than %d results", err.Limit)
+}
+
+// ErrStaleServers is returned when MonotonicReads is enabled, and every range
+// server reports a `%version` older than data the client has already observed.
+type ErrStaleServers struct {
+	Version int64
+}
+
+func (err ErrStaleServers) Error() string {
+	return fmt.Sprintf("cannot find range server with %%version of at least %d", err.Version)
+}
+
 // ErrParseException is returned by Client.Query method when an error occurs
 // while reading the io.ReadCloser from the response.
 type ErrParseException struct {
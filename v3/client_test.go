@@ -0,0 +1,62 @@
+package gorange
+
+import (
+	"net"
+	"net/url"
+	"reflect"
+	"testing"
+)
+
+func TestExpandLocallyMaxResults(t *testing.T) {
+	querier, err := NewQuerier(&Configurator{
+		Servers:       []string{"127.0.0.1:1"}, // never contacted
+		ExpandLocally: true,
+		MaxResults:    3,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = querier.Close() }()
+	client := querier.(*Client)
+
+	lines, err := client.Query("web{1..3}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"web1", "web2", "web3"}; !reflect.DeepEqual(lines, want) {
+		t.Errorf("Query = %q; want %q", lines, want)
+	}
+
+	_, err = client.Query("web{1..4}")
+	if _, ok := err.(ErrTooManyResults); !ok {
+		t.Errorf("Query error = %v; want ErrTooManyResults", err)
+	}
+
+	err = client.QueryEach("web{1..4}", func(string) error { return nil })
+	if _, ok := err.(ErrTooManyResults); !ok {
+		t.Errorf("QueryEach error = %v; want ErrTooManyResults", err)
+	}
+}
+
+func TestRetryCallbackUsesCurrentServers(t *testing.T) {
+	querier, err := NewQuerier(&Configurator{Servers: []string{"range1.example.com"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = querier.Close() }()
+	client := querier.(*Client)
+
+	noSuchHost := &url.Error{Op: "Get", URL: "http://range1.example.com", Err: &net.OpError{
+		Op:  "dial",
+		Err: &net.DNSError{Err: "no such host", Name: "range1.example.com"},
+	}}
+	if client.retryCallback(noSuchHost) {
+		t.Error("retry with only one range server")
+	}
+	if err = client.SetServers([]string{"range1.example.com", "range2.example.com"}); err != nil {
+		t.Fatal(err)
+	}
+	if !client.retryCallback(noSuchHost) {
+		t.Error("no retry after adding a second range server")
+	}
+}
@@ -0,0 +1,94 @@
+package gorange
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+)
+
+// flight is a single in-flight query whose result is shared by every caller
+// that requests the same expression while it is outstanding.
+type flight struct {
+	done    chan struct{} // closed after result and err are set
+	result  *Result
+	err     error
+	waiters int // number of callers still waiting for the result
+	cancel  context.CancelFunc
+}
+
+// flightGroup coalesces concurrent queries for the same expression so that only
+// one request is sent to the range servers.
+type flightGroup struct {
+	coalesced uint64 // accessed atomically; must be first for 64-bit alignment
+	flights   map[string]*flight
+	lock      sync.Mutex
+	pending   sync.WaitGroup // shared queries still running
+}
+
+// do returns the result of invoking query for the expression, sharing the
+// result of an outstanding invocation for the same expression when there is
+// one.  The shared query runs with its own context, which is canceled only once
+// every caller waiting for it has given up, so that one caller's cancellation
+// does not fail the others.  Every caller receives its own copy of the result.
+func (g *flightGroup) do(ctx context.Context, expression string, query func(context.Context, string) (*Result, error)) (*Result, error) {
+	g.lock.Lock()
+	if g.flights == nil {
+		g.flights = make(map[string]*flight)
+	}
+	f, ok := g.flights[expression]
+	if ok {
+		f.waiters++
+		atomic.AddUint64(&g.coalesced, 1)
+	} else {
+		fctx, cancel := context.WithCancel(context.Background())
+		f = &flight{done: make(chan struct{}), waiters: 1, cancel: cancel}
+		g.flights[expression] = f
+
+		g.pending.Add(1)
+		go func() {
+			defer g.pending.Done()
+			result, err := query(fctx, expression)
+			g.lock.Lock()
+			if g.flights[expression] == f {
+				delete(g.flights, expression)
+			}
+			g.lock.Unlock()
+			f.result, f.err = result, err
+			cancel() // release resources held by context
+			close(f.done)
+		}()
+	}
+	g.lock.Unlock()
+
+	select {
+	case <-f.done:
+		if f.err != nil {
+			return nil, f.err
+		}
+		return f.result.clone(), nil
+	case <-ctx.Done():
+		g.lock.Lock()
+		f.waiters--
+		if f.waiters == 0 {
+			// Nobody remains interested in the result, so abort the query, and
+			// ensure subsequent callers start a new one rather than join it.
+			f.cancel()
+			if g.flights[expression] == f {
+				delete(g.flights, expression)
+			}
+		}
+		g.lock.Unlock()
+		return nil, ctx.Err()
+	}
+}
+
+// close cancels every shared query still running, including those every caller
+// has given up on, and waits for them to return.
+func (g *flightGroup) close() {
+	g.lock.Lock()
+	for _, f := range g.flights {
+		f.cancel()
+	}
+	g.lock.Unlock()
+	g.pending.Wait()
+}
@@ -0,0 +1,161 @@
+package gorange
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// testRangeServer is a range server for tests that counts the requests it
+// receives, and responds to each using handler.
+type testRangeServer struct {
+	*httptest.Server
+	requests int64 // accessed atomically
+}
+
+func newTestRangeServer(handler http.HandlerFunc) *testRangeServer {
+	trs := new(testRangeServer)
+	trs.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&trs.requests, 1)
+		handler(w, r)
+	}))
+	return trs
+}
+
+func (trs *testRangeServer) count() int64 {
+	return atomic.LoadInt64(&trs.requests)
+}
+
+// blockingHandler responds with the query expression once release is closed,
+// or gives up when the request is canceled.
+func blockingHandler(release <-chan struct{}) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+			fmt.Fprintln(w, r.URL.RawQuery)
+		case <-r.Context().Done():
+		}
+	}
+}
+
+// waitFor polls condition until it returns true, failing the test when it does
+// not do so within a few seconds.
+func waitFor(t *testing.T, what string, condition func() bool) {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); !condition(); {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestCoalesceConcurrentQueries(t *testing.T) {
+	release := make(chan struct{})
+	server := newTestRangeServer(blockingHandler(release))
+	defer server.Close()
+
+	querier, err := NewQuerier(&Configurator{Servers: []string{server.URL}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = querier.Close() }()
+	client := querier.(*Client)
+
+	const callers = 4
+	var wg sync.WaitGroup
+	results := make([][]string, callers)
+	errs := make([]error, callers)
+	wg.Add(callers)
+	for i := 0; i < callers; i++ {
+		go func(i int) {
+			defer wg.Done()
+			results[i], errs[i] = client.Query("web1")
+		}(i)
+	}
+
+	waitFor(t, "callers to coalesce", func() bool { return client.Coalesced() == callers-1 })
+	close(release)
+	wg.Wait()
+
+	for i := 0; i < callers; i++ {
+		if errs[i] != nil {
+			t.Errorf("caller %d: %s", i, errs[i])
+		} else if want := []string{"web1"}; !reflect.DeepEqual(results[i], want) {
+			t.Errorf("caller %d: Query = %q; want %q", i, results[i], want)
+		}
+	}
+	if got := server.count(); got != 1 {
+		t.Errorf("range server received %d requests; want 1", got)
+	}
+}
+
+func TestCoalesceCanceledCallerDoesNotFailOthers(t *testing.T) {
+	release := make(chan struct{})
+	server := newTestRangeServer(blockingHandler(release))
+	defer server.Close()
+
+	querier, err := NewQuerier(&Configurator{Servers: []string{server.URL}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = querier.Close() }()
+	client := querier.(*Client)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	canceled := make(chan error, 1)
+	go func() {
+		_, err := client.QueryContext(ctx, "web1")
+		canceled <- err
+	}()
+	waitFor(t, "request to range server", func() bool { return server.count() == 1 })
+
+	other := make(chan error, 1)
+	go func() {
+		_, err := client.Query("web1")
+		other <- err
+	}()
+	waitFor(t, "callers to coalesce", func() bool { return client.Coalesced() == 1 })
+
+	cancel()
+	if err := <-canceled; err != context.Canceled {
+		t.Errorf("canceled caller error = %v; want %v", err, context.Canceled)
+	}
+	close(release)
+	if err := <-other; err != nil {
+		t.Errorf("other caller error = %v; want nil", err)
+	}
+}
+
+func TestCloseAfterCanceledQuery(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+	server := newTestRangeServer(blockingHandler(release))
+	defer server.Close()
+
+	for i := 0; i < 20; i++ {
+		querier, err := NewQuerier(&Configurator{Servers: []string{server.URL}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			_, _ = querier.(*Client).QueryContext(ctx, "web1")
+		}()
+		waitFor(t, "request to range server", func() bool { return server.count() == int64(i+1) })
+		cancel()
+		<-done
+		// The shared query may still be returning from the canceled request.
+		if err := querier.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
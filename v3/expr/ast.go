@@ -0,0 +1,228 @@
+// Package expr parses range expressions into an abstract syntax tree.
+//
+// The parser understands the common range syntax: cluster lookups such as
+// `%cluster` and `%cluster:KEY`, brace and bracket sets such as `foo{1-10}`
+// and `db[1-4]`, sequences such as `foo1..10`, the `,` union, `,-`
+// difference, and `,&` intersection operators, `/regex/` filters, the `^`
+// admin operator, `()` grouping, and function calls such as `has(KEY;VALUE)`
+// and `allclusters()`.  Function arguments are separated by semicolons.
+//
+// Parsing an expression locally allows a program to reject malformed queries
+// before sending them to a range server, and to inspect or rewrite queries by
+// walking and modifying the returned tree.  The String method of every node
+// renders that node back into range syntax.
+//
+//	node, err := expr.Parse("%cluster-web:HOSTS,-web01.example.com")
+//	if err != nil {
+//		fmt.Fprintf(os.Stderr, "%s\n", err)
+//		os.Exit(1)
+//	}
+//	expr.Walk(node, func(n expr.Node) bool {
+//		if c, ok := n.(*expr.Cluster); ok {
+//			fmt.Println("cluster lookup:", c.Operand)
+//		}
+//		return true
+//	})
+package expr
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Node is the interface implemented by every node of the abstract syntax tree.
+type Node interface {
+	// Pos returns the byte offset into the parsed expression where the node
+	// begins.
+	Pos() int
+
+	// String returns the range syntax representation of the node.
+	String() string
+}
+
+// Operator identifies the set operation performed by a Binary node.
+type Operator int
+
+const (
+	// Union is the `,` operator.
+	Union Operator = iota
+
+	// Difference is the `,-` operator.
+	Difference
+
+	// Intersection is the `,&` operator.
+	Intersection
+)
+
+// String returns the range syntax for the operator.
+func (op Operator) String() string {
+	switch op {
+	case Union:
+		return ","
+	case Difference:
+		return ",-"
+	case Intersection:
+		return ",&"
+	default:
+		return fmt.Sprintf("Operator(%d)", int(op))
+	}
+}
+
+// Word is a literal string, such as a host name.
+type Word struct {
+	Offset int
+	Text   string
+}
+
+func (n *Word) Pos() int       { return n.Offset }
+func (n *Word) String() string { return n.Text }
+
+// Sequence is an inclusive range of strings that differ by a number, such as
+// `foo1..10`, `foo01..foo10`, or the `1-10` in `foo{1-10}`.  Separator is
+// either `..` or `-`, the latter only being valid inside of a Brace.
+type Sequence struct {
+	Offset    int
+	First     string
+	Last      string
+	Separator string
+}
+
+func (n *Sequence) Pos() int       { return n.Offset }
+func (n *Sequence) String() string { return n.First + n.Separator + n.Last }
+
+// Brace is a set of alternatives enclosed in braces, such as the `{1-10}` in
+// `foo{1-10}`, or enclosed in square brackets when Square is true, such as the
+// `[1-4]` in `db[1-4]`.
+type Brace struct {
+	Offset int
+	Expr   Node
+	Square bool
+}
+
+func (n *Brace) Pos() int { return n.Offset }
+
+func (n *Brace) String() string {
+	if n.Square {
+		return "[" + n.Expr.String() + "]"
+	}
+	return "{" + n.Expr.String() + "}"
+}
+
+// Concat is the juxtaposition of Word, Sequence, and Brace nodes, such as
+// `web{01-40}.dc1`, which yields the cross product of its parts.
+type Concat struct {
+	Parts []Node
+}
+
+func (n *Concat) Pos() int { return n.Parts[0].Pos() }
+
+func (n *Concat) String() string {
+	var b strings.Builder
+	for _, part := range n.Parts {
+		b.WriteString(part.String())
+	}
+	return b.String()
+}
+
+// Cluster is a cluster lookup, such as `%cluster` or `%cluster:KEY`.  Key is
+// empty when no key is specified.
+type Cluster struct {
+	Offset  int
+	Operand Node
+	Key     string
+}
+
+func (n *Cluster) Pos() int { return n.Offset }
+
+func (n *Cluster) String() string {
+	if n.Key == "" {
+		return "%" + n.Operand.String()
+	}
+	return "%" + n.Operand.String() + ":" + n.Key
+}
+
+// Admin is the `^` operator, which yields the clusters that contain its
+// operand.
+type Admin struct {
+	Offset  int
+	Operand Node
+}
+
+func (n *Admin) Pos() int       { return n.Offset }
+func (n *Admin) String() string { return "^" + n.Operand.String() }
+
+// Regex is a regular expression filter, such as `/^web/`.  Pattern is the text
+// between the slashes, with any escaped slashes left escaped.
+type Regex struct {
+	Offset  int
+	Pattern string
+}
+
+func (n *Regex) Pos() int       { return n.Offset }
+func (n *Regex) String() string { return "/" + n.Pattern + "/" }
+
+// Group is an expression enclosed in parentheses.
+type Group struct {
+	Offset int
+	Expr   Node
+}
+
+func (n *Group) Pos() int       { return n.Offset }
+func (n *Group) String() string { return "(" + n.Expr.String() + ")" }
+
+// Call is a function call, such as `has(KEY;VALUE)` or `allclusters()`.
+type Call struct {
+	Offset int
+	Name   string
+	Args   []Node
+}
+
+func (n *Call) Pos() int { return n.Offset }
+
+func (n *Call) String() string {
+	args := make([]string, len(n.Args))
+	for i, arg := range n.Args {
+		args[i] = arg.String()
+	}
+	return n.Name + "(" + strings.Join(args, ";") + ")"
+}
+
+// Binary is a set operation between two expressions.  Operators are left
+// associative and share the same precedence.
+type Binary struct {
+	Op    Operator
+	Left  Node
+	Right Node
+}
+
+func (n *Binary) Pos() int       { return n.Left.Pos() }
+func (n *Binary) String() string { return n.Left.String() + n.Op.String() + n.Right.String() }
+
+// Walk traverses the tree rooted at node in depth-first order, invoking fn for
+// each node.  When fn returns false, the children of that node are not visited.
+func Walk(node Node, fn func(Node) bool) {
+	if node == nil || !fn(node) {
+		return
+	}
+	switch n := node.(type) {
+	case *Brace:
+		Walk(n.Expr, fn)
+	case *Concat:
+		for _, part := range n.Parts {
+			Walk(part, fn)
+		}
+	case *Cluster:
+		Walk(n.Operand, fn)
+	case *Admin:
+		Walk(n.Operand, fn)
+	case *Group:
+		Walk(n.Expr, fn)
+	case *Call:
+		for _, arg := range n.Args {
+			Walk(arg, fn)
+		}
+	case *Binary:
+		Walk(n.Left, fn)
+		Walk(n.Right, fn)
+	}
+}
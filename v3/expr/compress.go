@@ -0,0 +1,195 @@
+package expr
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// maxNumberDigits is the longest run of digits that Compress treats as a
+// number.  Longer runs cannot be represented as a uint64, and are treated as
+// literal text.
+const maxNumberDigits = 18
+
+// Compress returns a range expression that expands to the same set of strings
+// as the provided list, which is the inverse of Expand.  Strings that differ
+// only by the numbers they contain are combined using brace sets and
+// sequences, preserving any zero padding, so that a list such as `web01.dc1`,
+// `web02.dc1`, through `web40.dc1` compresses to `web{01..40}.dc1`, and
+// `rack1-node01` through `rack2-node40` compresses to
+// `rack{1,2}-node{01..40}`.  Duplicate strings are ignored.  Strings that
+// contain range syntax characters, such as commas or braces, are not escaped.
+//
+//	lines, err := querier.Query("%someQuery")
+//	if err != nil {
+//		fmt.Fprintf(os.Stderr, "ERROR: %s", err)
+//		os.Exit(1)
+//	}
+//	fmt.Println(expr.Compress(lines))
+func Compress(values []string) string {
+	sorted := make([]string, len(values))
+	copy(sorted, values)
+	sort.Strings(sorted)
+	sorted = unique(sorted)
+
+	// Group the strings by their structure, namely the text between the
+	// numbers, so only strings that differ by their numbers are combined.
+	var layouts []string
+	groups := make(map[string][][]string)
+	for _, value := range sorted {
+		segments := splitNumbers(value)
+		layout := layoutOf(segments)
+		if _, ok := groups[layout]; !ok {
+			layouts = append(layouts, layout)
+		}
+		groups[layout] = append(groups[layout], segments)
+	}
+
+	var results []string
+	for _, layout := range layouts {
+		for _, segments := range compressGroup(groups[layout]) {
+			results = append(results, strings.Join(segments, ""))
+		}
+	}
+	return strings.Join(results, ",")
+}
+
+// splitNumbers splits value into alternating runs of text and digits.
+func splitNumbers(value string) []string {
+	var segments []string
+	for i := 0; i < len(value); {
+		j := i
+		if isDigit(value[i]) {
+			for j < len(value) && isDigit(value[j]) {
+				j++
+			}
+		} else {
+			for j < len(value) && !isDigit(value[j]) {
+				j++
+			}
+		}
+		segments = append(segments, value[i:j])
+		i = j
+	}
+	return segments
+}
+
+// isNumber returns true when the segment is a run of digits short enough to be
+// treated as a number.
+func isNumber(segment string) bool {
+	return segment != "" && isDigit(segment[0]) && len(segment) <= maxNumberDigits
+}
+
+// layoutOf returns a key that is the same for all values whose segments differ
+// only by their numbers.
+func layoutOf(segments []string) string {
+	layout := make([]string, len(segments))
+	for i, segment := range segments {
+		if isNumber(segment) {
+			layout[i] = "#"
+		} else {
+			layout[i] = "=" + segment
+		}
+	}
+	return strings.Join(layout, "\x00")
+}
+
+// compressGroup combines segment lists that share a layout.  Working from the
+// rightmost number to the leftmost, it merges the lists that are identical
+// other than at that number, replacing the number with the set of numbers from
+// the merged lists.  Each merge is exact, because every merged list represents
+// the cross product of its segments, and all of them share the other segments.
+func compressGroup(group [][]string) [][]string {
+	for d := len(group[0]) - 1; d >= 0; d-- {
+		if !isNumber(group[0][d]) {
+			continue
+		}
+
+		var keys []string
+		merged := make(map[string][]string)
+		numbers := make(map[string][]string)
+
+		for _, segments := range group {
+			key := strings.Join(segments[:d], "") + "\x00" + strings.Join(segments[d+1:], "")
+			if _, ok := merged[key]; !ok {
+				keys = append(keys, key)
+				merged[key] = segments
+			}
+			numbers[key] = append(numbers[key], segments[d])
+		}
+
+		group = group[:0]
+		for _, key := range keys {
+			segments := append([]string(nil), merged[key]...)
+			segments[d] = compressNumbers(numbers[key])
+			group = append(group, segments)
+		}
+	}
+	return group
+}
+
+// compressNumbers returns the brace set representing the list of numbers, such
+// as `{01..03,07}`, or the number itself when the list has a single number.
+func compressNumbers(numbers []string) string {
+	if len(numbers) == 1 {
+		return numbers[0]
+	}
+
+	values := make([]uint64, len(numbers))
+	for i, number := range numbers {
+		values[i], _ = strconv.ParseUint(number, 10, 64) // isNumber guarantees no error
+	}
+	sort.Sort(byValue{numbers, values})
+
+	var runs []string
+	for i := 0; i < len(numbers); {
+		// Extend the run while each number is the successor of the previous
+		// number and is formatted with the same zero padding.
+		var width int
+		if len(numbers[i]) > 1 && numbers[i][0] == '0' {
+			width = len(numbers[i])
+		}
+		j := i + 1
+		for j < len(numbers) && values[j] == values[j-1]+1 && numbers[j] == padNumber(values[j], width) {
+			j++
+		}
+		switch j - i {
+		case 1:
+			runs = append(runs, numbers[i])
+		case 2:
+			runs = append(runs, numbers[i], numbers[i+1])
+		default:
+			runs = append(runs, numbers[i]+".."+numbers[j-1])
+		}
+		i = j
+	}
+	return "{" + strings.Join(runs, ",") + "}"
+}
+
+func padNumber(value uint64, width int) string {
+	s := strconv.FormatUint(value, 10)
+	if len(s) < width {
+		s = strings.Repeat("0", width-len(s)) + s
+	}
+	return s
+}
+
+// byValue sorts numbers by their numeric value, then by their zero padding.
+type byValue struct {
+	numbers []string
+	values  []uint64
+}
+
+func (b byValue) Len() int { return len(b.numbers) }
+
+func (b byValue) Less(i, j int) bool {
+	if b.values[i] != b.values[j] {
+		return b.values[i] < b.values[j]
+	}
+	return b.numbers[i] < b.numbers[j]
+}
+
+func (b byValue) Swap(i, j int) {
+	b.numbers[i], b.numbers[j] = b.numbers[j], b.numbers[i]
+	b.values[i], b.values[j] = b.values[j], b.values[i]
+}
@@ -0,0 +1,69 @@
+package expr
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestCompressRoundTrip(t *testing.T) {
+	cases := []struct {
+		expression string // expanded, then compressed
+		want       string
+	}{
+		{"foo", "foo"},
+		{"web{01..40}.dc1", "web{01..40}.dc1"},               // padding and suffix
+		{"foo{0..12}", "foo{0..12}"},                         // number widths vary
+		{"host9..11", "host{9..11}"},                         // sequence without braces
+		{"foo{1-10},-foo5", "foo{1..4,6..10}"},               // gap splits runs
+		{"foo{1,2,4}", "foo{1,2,4}"},                         // short runs are listed
+		{"rack{1,2}-node{01..40}", "rack{1,2}-node{01..40}"}, // several numbers
+		{"a{1..3}b{2,4}.c", "a{1..3}b{2,4}.c"},
+		{"web{08..11},web9", "web{08,09,9..11}"}, // padded and unpadded kept apart
+		{"db1,web1,web2", "db1,web{1,2}"},        // layouts grouped separately
+	}
+	for _, c := range cases {
+		node, err := Parse(c.expression)
+		if err != nil {
+			t.Fatalf("Parse(%q): %s", c.expression, err)
+		}
+		values, err := Expand(node)
+		if err != nil {
+			t.Fatalf("Expand(%q): %s", c.expression, err)
+		}
+
+		got := Compress(values)
+		if got != c.want {
+			t.Errorf("Compress(%q) = %q; want %q", values, got, c.want)
+		}
+
+		// The compressed expression must expand to exactly the same strings.
+		node, err = Parse(got)
+		if err != nil {
+			t.Errorf("Parse(Compress(%q)): %s", values, err)
+			continue
+		}
+		again, err := Expand(node)
+		if err != nil {
+			t.Errorf("Expand(Compress(%q)): %s", values, err)
+			continue
+		}
+		sort.Strings(values)
+		sort.Strings(again)
+		if !reflect.DeepEqual(again, values) {
+			t.Errorf("Expand(Compress(%q)) = %q", values, again)
+		}
+	}
+}
+
+func TestCompressDuplicates(t *testing.T) {
+	if got, want := Compress([]string{"web2", "web1", "web2"}), "web{1,2}"; got != want {
+		t.Errorf("Compress = %q; want %q", got, want)
+	}
+}
+
+func TestCompressEmpty(t *testing.T) {
+	if got := Compress(nil); got != "" {
+		t.Errorf("Compress(nil) = %q; want empty", got)
+	}
+}
@@ -0,0 +1,243 @@
+package expr
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// MaxExpansion is the maximum number of strings that Expand will produce for
+// any single node, guarding against expressions such as `foo1..999999999`
+// exhausting memory.
+const MaxExpansion = 1 << 20
+
+// ErrNotLocal is returned by Expand when the expression contains a node that
+// can only be resolved by a range server, such as a cluster lookup.
+type ErrNotLocal struct {
+	Node Node
+}
+
+func (err ErrNotLocal) Error() string {
+	return fmt.Sprintf("cannot expand without range server: %q", err.Node.String())
+}
+
+// IsLocal returns true when the expression is self-contained, that is, when it
+// contains no cluster lookups, admin operators, regular expressions, or
+// function calls, and therefore may be expanded without a range server.
+func IsLocal(node Node) bool {
+	local := true
+	Walk(node, func(n Node) bool {
+		switch n.(type) {
+		case *Cluster, *Admin, *Regex, *Call:
+			local = false
+		}
+		return local
+	})
+	return local
+}
+
+// Expand returns the list of strings the self-contained expression represents,
+// in the order in which they appear in the expression, without duplicates.  It
+// returns ErrNotLocal when the expression requires a range server to resolve.
+//
+//	node, err := expr.Parse("web{01-03}.dc1,-web02.dc1")
+//	if err != nil {
+//		fmt.Fprintf(os.Stderr, "%s\n", err)
+//		os.Exit(1)
+//	}
+//	hosts, err := expr.Expand(node) // [web01.dc1 web03.dc1]
+func Expand(node Node) ([]string, error) {
+	switch n := node.(type) {
+	case *Word:
+		return []string{n.Text}, nil
+	case *Sequence:
+		return expandSequence(n)
+	case *Brace:
+		return Expand(n.Expr)
+	case *Group:
+		return Expand(n.Expr)
+	case *Concat:
+		return expandConcat(n)
+	case *Binary:
+		return expandBinary(n)
+	default:
+		return nil, ErrNotLocal{Node: node}
+	}
+}
+
+func expandConcat(n *Concat) ([]string, error) {
+	results := []string{""}
+	for _, part := range n.Parts {
+		values, err := Expand(part)
+		if err != nil {
+			return nil, err
+		}
+		if len(results)*len(values) > MaxExpansion {
+			return nil, fmt.Errorf("cannot expand %q: more than %d results", n.String(), MaxExpansion)
+		}
+		product := make([]string, 0, len(results)*len(values))
+		for _, prefix := range results {
+			for _, value := range values {
+				product = append(product, prefix+value)
+			}
+		}
+		results = product
+	}
+	return unique(results), nil
+}
+
+func expandBinary(n *Binary) ([]string, error) {
+	left, err := Expand(n.Left)
+	if err != nil {
+		return nil, err
+	}
+	right, err := Expand(n.Right)
+	if err != nil {
+		return nil, err
+	}
+
+	switch n.Op {
+	case Union:
+		return unique(append(left, right...)), nil
+	case Difference, Intersection:
+		others := make(map[string]struct{}, len(right))
+		for _, value := range right {
+			others[value] = struct{}{}
+		}
+		keep := n.Op == Intersection
+		results := left[:0]
+		for _, value := range left {
+			if _, ok := others[value]; ok == keep {
+				results = append(results, value)
+			}
+		}
+		return results, nil
+	default:
+		return nil, fmt.Errorf("cannot expand unknown operator: %s", n.Op)
+	}
+}
+
+// expandSequence expands sequences such as `foo1..10`, `foo1..foo10`,
+// `foo1..10.example.com`, `foo1.example.com..foo10.example.com`, and the `1-10`
+// from `{1-10}`.  When the first number has leading zeros, every number is
+// padded with zeros to the same width.
+func expandSequence(n *Sequence) ([]string, error) {
+	prefix, first, last, suffix, err := splitSequence(n.First, n.Last)
+	if err != nil {
+		return nil, fmt.Errorf("cannot expand %q: %s", n.String(), err)
+	}
+
+	low, err := strconv.ParseUint(first, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("cannot expand %q: %s", n.String(), err)
+	}
+	high, err := strconv.ParseUint(last, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("cannot expand %q: %s", n.String(), err)
+	}
+	if high < low {
+		return nil, fmt.Errorf("cannot expand %q: descending sequence", n.String())
+	}
+	if high-low >= MaxExpansion {
+		return nil, fmt.Errorf("cannot expand %q: more than %d results", n.String(), MaxExpansion)
+	}
+
+	var width int
+	if len(first) > 1 && first[0] == '0' {
+		width = len(first)
+	}
+
+	results := make([]string, 0, high-low+1)
+	for i := low; ; i++ {
+		results = append(results, prefix+fmt.Sprintf("%0*d", width, i)+suffix)
+		if i == high {
+			break
+		}
+	}
+	return results, nil
+}
+
+// splitSequence finds the common prefix, the first and last numbers, and the
+// common suffix of the two ends of a sequence.
+func splitSequence(first, last string) (string, string, string, string, error) {
+	var prefix, suffix string
+
+	if isDigit(last[0]) {
+		// The last string omits the prefix, as in `foo1..10`, `foo1..10.dc1`,
+		// or `foo1.dc1..10.dc1`.
+		i := leadingDigits(last)
+		suffix = last[i:]
+		last = last[:i]
+		if suffix != "" && strings.HasSuffix(first, suffix) {
+			first = first[:len(first)-len(suffix)]
+		}
+		j := trailingDigits(first)
+		if j == len(first) {
+			return "", "", "", "", fmt.Errorf("%q does not end with a number", first)
+		}
+		return first[:j], first[j:], last, suffix, nil
+	}
+
+	// The last string is complete, as in `foo1.dc1..foo10.dc1`, so both strings
+	// must share a prefix and a suffix around the number in which they differ.
+	n := len(first)
+	if len(last) < n {
+		n = len(last)
+	}
+	p := 0
+	for p < n && first[p] == last[p] {
+		p++
+	}
+	for p > 0 && isDigit(first[p-1]) {
+		p-- // common digits belong to the number
+	}
+	s := 0
+	for s < n-p && first[len(first)-1-s] == last[len(last)-1-s] {
+		s++
+	}
+	for s > 0 && isDigit(last[len(last)-s]) {
+		s-- // common digits belong to the number
+	}
+
+	prefix, suffix = first[:p], first[len(first)-s:]
+	first, last = first[p:len(first)-s], last[p:len(last)-s]
+	if !isDigits(first) || !isDigits(last) {
+		return "", "", "", "", fmt.Errorf("%q and %q do not differ by a number", prefix+first+suffix, prefix+last+suffix)
+	}
+	return prefix, first, last, suffix, nil
+}
+
+func isDigit(b byte) bool { return b >= '0' && b <= '9' }
+
+// leadingDigits returns the index of the first non-digit byte in s.
+func leadingDigits(s string) int {
+	i := 0
+	for i < len(s) && isDigit(s[i]) {
+		i++
+	}
+	return i
+}
+
+// trailingDigits returns the index of the first byte of the trailing run of
+// digits in s, or len(s) when s does not end with a digit.
+func trailingDigits(s string) int {
+	i := len(s)
+	for i > 0 && isDigit(s[i-1]) {
+		i--
+	}
+	return i
+}
+
+// unique removes duplicate strings in place, preserving the order of their
+// first appearance.
+func unique(values []string) []string {
+	seen := make(map[string]struct{}, len(values))
+	results := values[:0]
+	for _, value := range values {
+		if _, ok := seen[value]; !ok {
+			seen[value] = struct{}{}
+			results = append(results, value)
+		}
+	}
+	return results
+}
@@ -0,0 +1,387 @@
+package expr
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// SyntaxError is returned by Parse when an expression is malformed.  Offset is
+// the byte offset into the expression where the error was detected.
+type SyntaxError struct {
+	Offset  int
+	Message string
+}
+
+func (err SyntaxError) Error() string {
+	return fmt.Sprintf("syntax error at offset %d: %s", err.Offset, err.Message)
+}
+
+type tokenType int
+
+const (
+	tokEOF tokenType = iota
+	tokWord
+	tokUnion        // ,
+	tokDifference   // ,-
+	tokIntersection // ,&
+	tokPercent      // %
+	tokCaret        // ^
+	tokRegex        // /pattern/
+	tokLParen       // (
+	tokRParen       // )
+	tokLBrace       // {
+	tokRBrace       // }
+	tokLBracket     // [
+	tokRBracket     // ]
+	tokColon        // :
+	tokSemicolon    // ;
+)
+
+type token struct {
+	typ    tokenType
+	offset int
+	text   string
+	spaced bool // true when whitespace immediately precedes token
+}
+
+func (t token) describe() string {
+	switch t.typ {
+	case tokEOF:
+		return "end of expression"
+	case tokWord:
+		return fmt.Sprintf("word %q", t.text)
+	default:
+		return fmt.Sprintf("%q", t.text)
+	}
+}
+
+var punctuation = map[byte]tokenType{
+	'%': tokPercent,
+	'^': tokCaret,
+	'(': tokLParen,
+	')': tokRParen,
+	'{': tokLBrace,
+	'}': tokRBrace,
+	'[': tokLBracket,
+	']': tokRBracket,
+	':': tokColon,
+	';': tokSemicolon,
+}
+
+func isWordByte(b byte) bool {
+	if b == ',' || b == '/' || b == ' ' || b == '\t' || b == '\n' || b == '\r' {
+		return false
+	}
+	_, ok := punctuation[b]
+	return !ok
+}
+
+// lex splits the expression into tokens, always terminated by a tokEOF token.
+func lex(expression string) ([]token, error) {
+	var tokens []token
+	var spaced bool
+
+	for i := 0; i < len(expression); {
+		b := expression[i]
+
+		if b < utf8.RuneSelf {
+			if b == ' ' || b == '\t' || b == '\n' || b == '\r' {
+				spaced = true
+				i++
+				continue
+			}
+		} else if r, size := utf8.DecodeRuneInString(expression[i:]); unicode.IsSpace(r) {
+			spaced = true
+			i += size
+			continue
+		}
+
+		t := token{offset: i, spaced: spaced}
+		spaced = false
+
+		switch {
+		case b == ',':
+			t.typ = tokUnion
+			if i+1 < len(expression) {
+				switch expression[i+1] {
+				case '-':
+					t.typ = tokDifference
+				case '&':
+					t.typ = tokIntersection
+				}
+			}
+			if t.typ == tokUnion {
+				t.text = expression[i : i+1]
+			} else {
+				t.text = expression[i : i+2]
+			}
+		case b == '/':
+			j := i + 1
+			for ; j < len(expression) && expression[j] != '/'; j++ {
+				if expression[j] == '\\' {
+					j++ // skip escaped character
+				}
+			}
+			if j >= len(expression) {
+				return nil, SyntaxError{Offset: i, Message: "unterminated regular expression"}
+			}
+			t.typ = tokRegex
+			t.text = expression[i : j+1]
+		default:
+			if typ, ok := punctuation[b]; ok {
+				t.typ = typ
+				t.text = expression[i : i+1]
+				break
+			}
+			j := i
+			for j < len(expression) && isWordByte(expression[j]) {
+				if expression[j] >= utf8.RuneSelf {
+					if r, _ := utf8.DecodeRuneInString(expression[j:]); unicode.IsSpace(r) {
+						break
+					}
+				}
+				j++
+			}
+			t.typ = tokWord
+			t.text = expression[i:j]
+		}
+
+		tokens = append(tokens, t)
+		i += len(t.text)
+	}
+
+	return append(tokens, token{typ: tokEOF, offset: len(expression), spaced: spaced}), nil
+}
+
+type parser struct {
+	tokens []token
+	i      int
+}
+
+// Parse converts the range expression into an abstract syntax tree, or returns
+// a SyntaxError describing the first problem found in the expression.
+//
+//	node, err := expr.Parse("foo{1-10},-foo5")
+//	if err != nil {
+//		fmt.Fprintf(os.Stderr, "%s\n", err)
+//		os.Exit(1)
+//	}
+//	fmt.Println(node)
+func Parse(expression string) (Node, error) {
+	tokens, err := lex(expression)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{tokens: tokens}
+	node, err := p.parseExpr(false)
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.typ != tokEOF {
+		return nil, p.unexpected(t)
+	}
+	return node, nil
+}
+
+func (p *parser) peek() token { return p.tokens[p.i] }
+
+func (p *parser) next() token {
+	t := p.tokens[p.i]
+	if t.typ != tokEOF {
+		p.i++
+	}
+	return t
+}
+
+func (p *parser) unexpected(t token) error {
+	return SyntaxError{Offset: t.offset, Message: "unexpected " + t.describe()}
+}
+
+func (p *parser) expect(typ tokenType, what string) error {
+	if t := p.peek(); t.typ != typ {
+		return SyntaxError{Offset: t.offset, Message: fmt.Sprintf("expected %s; found %s", what, t.describe())}
+	}
+	p.next()
+	return nil
+}
+
+// parseExpr parses a sequence of terms joined by set operators.  When inBrace
+// is true, numeric words such as `1-10` are parsed as sequences.
+func (p *parser) parseExpr(inBrace bool) (Node, error) {
+	left, err := p.parseTerm(inBrace)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		var op Operator
+		switch p.peek().typ {
+		case tokUnion:
+			op = Union
+		case tokDifference:
+			op = Difference
+		case tokIntersection:
+			op = Intersection
+		default:
+			return left, nil
+		}
+		p.next()
+		right, err := p.parseTerm(inBrace)
+		if err != nil {
+			return nil, err
+		}
+		left = &Binary{Op: op, Left: left, Right: right}
+	}
+}
+
+func (p *parser) parseTerm(inBrace bool) (Node, error) {
+	t := p.peek()
+	switch t.typ {
+	case tokPercent:
+		p.next()
+		operand, err := p.parseTerm(inBrace)
+		if err != nil {
+			return nil, err
+		}
+		node := &Cluster{Offset: t.offset, Operand: operand}
+		if c := p.peek(); c.typ == tokColon && !c.spaced {
+			p.next()
+			k := p.peek()
+			if k.typ != tokWord || k.spaced {
+				return nil, SyntaxError{Offset: k.offset, Message: "expected cluster key; found " + k.describe()}
+			}
+			p.next()
+			node.Key = k.text
+		}
+		return node, nil
+	case tokCaret:
+		p.next()
+		operand, err := p.parseTerm(inBrace)
+		if err != nil {
+			return nil, err
+		}
+		return &Admin{Offset: t.offset, Operand: operand}, nil
+	case tokRegex:
+		p.next()
+		return &Regex{Offset: t.offset, Pattern: t.text[1 : len(t.text)-1]}, nil
+	case tokLParen:
+		p.next()
+		node, err := p.parseExpr(false)
+		if err != nil {
+			return nil, err
+		}
+		if err = p.expect(tokRParen, "')'"); err != nil {
+			return nil, err
+		}
+		return &Group{Offset: t.offset, Expr: node}, nil
+	case tokWord, tokLBrace, tokLBracket:
+		return p.parseConcat(inBrace)
+	default:
+		return nil, p.unexpected(t)
+	}
+}
+
+// parseConcat parses adjacent words, sequences, and braces, or a function call.
+func (p *parser) parseConcat(inBrace bool) (Node, error) {
+	var parts []Node
+
+loop:
+	for {
+		t := p.peek()
+		if len(parts) > 0 && t.spaced {
+			break
+		}
+		switch t.typ {
+		case tokWord:
+			p.next()
+			if len(parts) == 0 {
+				if l := p.peek(); l.typ == tokLParen && !l.spaced {
+					return p.parseCall(t)
+				}
+			}
+			node, err := newWordNode(t, inBrace)
+			if err != nil {
+				return nil, err
+			}
+			parts = append(parts, node)
+		case tokLBrace, tokLBracket:
+			p.next()
+			node, err := p.parseExpr(true)
+			if err != nil {
+				return nil, err
+			}
+			square := t.typ == tokLBracket
+			if square {
+				err = p.expect(tokRBracket, "']'")
+			} else {
+				err = p.expect(tokRBrace, "'}'")
+			}
+			if err != nil {
+				return nil, err
+			}
+			parts = append(parts, &Brace{Offset: t.offset, Expr: node, Square: square})
+		default:
+			if len(parts) == 0 {
+				return nil, p.unexpected(t)
+			}
+			break loop
+		}
+	}
+
+	if len(parts) == 1 {
+		return parts[0], nil
+	}
+	return &Concat{Parts: parts}, nil
+}
+
+func (p *parser) parseCall(name token) (Node, error) {
+	p.next() // consume opening parenthesis
+	node := &Call{Offset: name.offset, Name: name.text}
+
+	if p.peek().typ == tokRParen {
+		p.next()
+		return node, nil
+	}
+
+	for {
+		arg, err := p.parseExpr(false)
+		if err != nil {
+			return nil, err
+		}
+		node.Args = append(node.Args, arg)
+
+		switch t := p.next(); t.typ {
+		case tokSemicolon:
+			// another argument follows
+		case tokRParen:
+			return node, nil
+		default:
+			return nil, SyntaxError{Offset: t.offset, Message: "expected ';' or ')'; found " + t.describe()}
+		}
+	}
+}
+
+// newWordNode returns either a Word or a Sequence node for the word token.
+func newWordNode(t token, inBrace bool) (Node, error) {
+	if i := strings.Index(t.text, ".."); i >= 0 {
+		first, last := t.text[:i], t.text[i+2:]
+		if first == "" || last == "" {
+			return nil, SyntaxError{Offset: t.offset + i, Message: fmt.Sprintf("incomplete sequence: %q", t.text)}
+		}
+		return &Sequence{Offset: t.offset, First: first, Last: last, Separator: ".."}, nil
+	}
+	if inBrace {
+		if i := strings.IndexByte(t.text, '-'); i > 0 && isDigits(t.text[:i]) && isDigits(t.text[i+1:]) {
+			return &Sequence{Offset: t.offset, First: t.text[:i], Last: t.text[i+1:], Separator: "-"}, nil
+		}
+	}
+	return &Word{Offset: t.offset, Text: t.text}, nil
+}
+
+func isDigits(s string) bool {
+	if s == "" {
+		return false
+	}
+	return leadingDigits(s) == len(s)
+}
@@ -0,0 +1,84 @@
+package expr
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseExpand(t *testing.T) {
+	cases := []struct {
+		expression string
+		want       []string
+	}{
+		{"foo", []string{"foo"}},
+		{"a,b,a", []string{"a", "b"}},
+		{"a1..3", []string{"a1", "a2", "a3"}},
+		{"host9..11", []string{"host9", "host10", "host11"}},
+		{"web{01..03}.dc1", []string{"web01.dc1", "web02.dc1", "web03.dc1"}},
+		{"foo{1-3}", []string{"foo1", "foo2", "foo3"}},
+		{"foo{1-6},-foo{2,4}", []string{"foo1", "foo3", "foo5", "foo6"}},
+		{"(a,b,c),&(b,c,d)", []string{"b", "c"}},
+		{"rack{1,2}-node{01..02}", []string{"rack1-node01", "rack1-node02", "rack2-node01", "rack2-node02"}},
+	}
+	for _, c := range cases {
+		node, err := Parse(c.expression)
+		if err != nil {
+			t.Errorf("Parse(%q): %s", c.expression, err)
+			continue
+		}
+		if got := node.String(); got != c.expression {
+			t.Errorf("Parse(%q).String() = %q", c.expression, got)
+		}
+		got, err := Expand(node)
+		if err != nil {
+			t.Errorf("Expand(%q): %s", c.expression, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, c.want) {
+			t.Errorf("Expand(%q) = %q; want %q", c.expression, got, c.want)
+		}
+	}
+}
+
+func TestParseSyntaxError(t *testing.T) {
+	cases := []struct {
+		expression string
+		offset     int
+	}{
+		{"{", 1},
+		{"a}", 1},
+		{"a,,b", 2},
+		{"a,-", 3},
+		{"foo{1..3", 8},
+		{"x/y/", 1},
+	}
+	for _, c := range cases {
+		_, err := Parse(c.expression)
+		se, ok := err.(SyntaxError)
+		if !ok {
+			t.Errorf("Parse(%q) error = %v; want SyntaxError", c.expression, err)
+			continue
+		}
+		if se.Offset != c.offset {
+			t.Errorf("Parse(%q) error offset = %d; want %d (%s)", c.expression, se.Offset, c.offset, se)
+		}
+	}
+}
+
+func TestExpandNotLocal(t *testing.T) {
+	for _, expression := range []string{"%cluster", "^host", "/web/", "a,%cluster"} {
+		node, err := Parse(expression)
+		if err != nil {
+			t.Errorf("Parse(%q): %s", expression, err)
+			continue
+		}
+		if IsLocal(node) {
+			t.Errorf("IsLocal(%q) = true", expression)
+		}
+		if _, err = Expand(node); err == nil {
+			t.Errorf("Expand(%q) returned no error", expression)
+		} else if _, ok := err.(ErrNotLocal); !ok {
+			t.Errorf("Expand(%q) error = %v; want ErrNotLocal", expression, err)
+		}
+	}
+}
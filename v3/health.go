@@ -0,0 +1,239 @@
+package gorange
+
+import (
+	"strconv"
+	"sync"
+	"time"
+)
+
+// DefaultCircuitBreakerCooldown is used when circuit breaking is enabled but no
+// CircuitBreakerCooldown is provided, to control how long a range server is
+// ejected before it is probed.
+const DefaultCircuitBreakerCooldown = 30 * time.Second
+
+// CircuitState describes whether a range server is eligible to receive
+// queries.
+type CircuitState int
+
+const (
+	// CircuitClosed means the range server is healthy and receives queries.
+	CircuitClosed CircuitState = iota
+
+	// CircuitOpen means the range server has been ejected after repeated
+	// failures, and does not receive queries unless every range server is
+	// ejected.
+	CircuitOpen
+
+	// CircuitHalfOpen means the range server is ejected, and a background
+	// probe is determining whether it has recovered.
+	CircuitHalfOpen
+)
+
+func (cs CircuitState) String() string {
+	switch cs {
+	case CircuitClosed:
+		return "closed"
+	case CircuitOpen:
+		return "open"
+	case CircuitHalfOpen:
+		return "half-open"
+	default:
+		return "CircuitState(" + strconv.Itoa(int(cs)) + ")"
+	}
+}
+
+// ServerStatus reports the health of a single range server.
+type ServerStatus struct {
+	// Server is the range server address.
+	Server string
+
+	// State is the state of the range server's circuit breaker.
+	State CircuitState
+
+	// Failures is the number of consecutive failed requests to the range
+	// server.
+	Failures int
+
+	// LastError is the error from the most recent failed request, or nil when
+	// the range server has not failed.
+	LastError error
+
+	// LastFailure is the time of the most recent failed request.
+	LastFailure time.Time
+}
+
+type serverHealth struct {
+	state       CircuitState
+	failures    int
+	lastError   error
+	lastFailure time.Time
+	timer       *time.Timer // schedules the next probe while circuit is open
+}
+
+// healthTracker counts consecutive failures for each range server, and ejects
+// a server once its failures reach the threshold.  Ejected servers are probed
+// in the background after each cooldown period, and returned to service when a
+// probe or a query succeeds.
+type healthTracker struct {
+	cooldown  time.Duration
+	threshold int
+	probe     func(server string) error
+
+	lock    sync.Mutex
+	order   []string
+	servers map[string]*serverHealth
+	closed  bool
+	probing sync.WaitGroup // in-flight probes
+}
+
+func newHealthTracker(servers []string, threshold int, cooldown time.Duration, probe func(string) error) *healthTracker {
+	ht := &healthTracker{
+		cooldown:  cooldown,
+		threshold: threshold,
+		probe:     probe,
+		order:     append([]string(nil), servers...),
+		servers:   make(map[string]*serverHealth, len(servers)),
+	}
+	for _, server := range servers {
+		ht.servers[server] = new(serverHealth)
+	}
+	return ht
+}
+
+// available returns true when the server's circuit is closed.
+func (ht *healthTracker) available(server string) bool {
+	ht.lock.Lock()
+	defer ht.lock.Unlock()
+	sh, ok := ht.servers[server]
+	return !ok || sh.state == CircuitClosed
+}
+
+func (ht *healthTracker) success(server string) {
+	ht.lock.Lock()
+	defer ht.lock.Unlock()
+	sh, ok := ht.servers[server]
+	if !ok {
+		return
+	}
+	sh.failures = 0
+	sh.state = CircuitClosed
+	if sh.timer != nil {
+		sh.timer.Stop()
+		sh.timer = nil
+	}
+}
+
+func (ht *healthTracker) failure(server string, err error) {
+	ht.lock.Lock()
+	defer ht.lock.Unlock()
+	sh, ok := ht.servers[server]
+	if !ok {
+		return
+	}
+	sh.failures++
+	sh.lastError = err
+	sh.lastFailure = time.Now()
+
+	switch sh.state {
+	case CircuitClosed:
+		if sh.failures < ht.threshold {
+			return
+		}
+	case CircuitOpen:
+		return // probe already scheduled
+	}
+	sh.state = CircuitOpen
+	if !ht.closed {
+		sh.timer = time.AfterFunc(ht.cooldown, func() { ht.runProbe(server) })
+	}
+}
+
+// runProbe probes an ejected server, closing its circuit when the probe
+// succeeds, and scheduling another probe when it fails.
+func (ht *healthTracker) runProbe(server string) {
+	ht.lock.Lock()
+	sh, ok := ht.servers[server]
+	if !ok || ht.closed || sh.state != CircuitOpen {
+		ht.lock.Unlock()
+		return
+	}
+	sh.state = CircuitHalfOpen
+	sh.timer = nil
+	ht.probing.Add(1)
+	ht.lock.Unlock()
+
+	defer ht.probing.Done()
+	if err := ht.probe(server); err != nil {
+		ht.failure(server, err)
+		return
+	}
+	ht.success(server)
+}
+
+// setServers starts tracking servers not previously tracked, and stops tracking
+// servers no longer listed, canceling their scheduled probes.
+func (ht *healthTracker) setServers(servers []string) {
+	ht.lock.Lock()
+	defer ht.lock.Unlock()
+	listed := make(map[string]*serverHealth, len(servers))
+	for _, server := range servers {
+		sh, ok := ht.servers[server]
+		if !ok {
+			sh = new(serverHealth)
+		}
+		listed[server] = sh
+	}
+	for server, sh := range ht.servers {
+		if _, ok := listed[server]; !ok && sh.timer != nil {
+			sh.timer.Stop()
+			sh.timer = nil
+		}
+	}
+	ht.order = append([]string(nil), servers...)
+	ht.servers = listed
+}
+
+func (ht *healthTracker) statuses() []ServerStatus {
+	ht.lock.Lock()
+	defer ht.lock.Unlock()
+	statuses := make([]ServerStatus, 0, len(ht.order))
+	for _, server := range ht.order {
+		sh := ht.servers[server]
+		statuses = append(statuses, ServerStatus{
+			Server:      server,
+			State:       sh.state,
+			Failures:    sh.failures,
+			LastError:   sh.lastError,
+			LastFailure: sh.lastFailure,
+		})
+	}
+	return statuses
+}
+
+// close stops all scheduled probes, and waits for any in-flight probes to
+// complete.
+func (ht *healthTracker) close() {
+	ht.lock.Lock()
+	ht.closed = true
+	for _, sh := range ht.servers {
+		if sh.timer != nil {
+			sh.timer.Stop()
+			sh.timer = nil
+		}
+	}
+	ht.lock.Unlock()
+	ht.probing.Wait()
+}
+
+// isServerFailure returns true when the error from a request indicates the
+// range server itself is unhealthy, rather than the query being invalid.
+func isServerFailure(err error) bool {
+	switch e := err.(type) {
+	case ErrRangeException:
+		return false
+	case ErrStatusNotOK:
+		return e.StatusCode >= 500
+	default:
+		return true
+	}
+}
@@ -0,0 +1,106 @@
+package gorange
+
+import (
+	"context"
+	"io"
+	"time"
+)
+
+// DefaultHedgeBudgetRatio is used when HedgeDelay is provided but no
+// HedgeBudgetRatio, to limit hedged requests to a fraction of queries.
+const DefaultHedgeBudgetRatio = 0.1
+
+// cancelReadCloser releases the context of a request once its response body
+// is closed.
+type cancelReadCloser struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (crc cancelReadCloser) Close() error {
+	err := crc.ReadCloser.Close()
+	crc.cancel()
+	return err
+}
+
+// getFromRangeServerHedged sends the query to a range server, and each time
+// the hedge delay elapses without a response, sends the same query to another
+// range server, up to the configured maximum number of hedged requests, and
+// while the hedge budget allows.  It returns the first successful response,
+// and cancels the others.  When every request fails, it returns the error from
+// the last one to fail.
+func (c *Client) getFromRangeServerHedged(ctx context.Context, path, expression string) (*serverResponse, error) {
+	type result struct {
+		index int
+		resp  *serverResponse
+		err   error
+	}
+
+	results := make(chan result, c.maxHedges+1) // buffered so requests never block
+	var cancels []context.CancelFunc
+
+	launch := func() {
+		rctx, cancel := context.WithCancel(ctx)
+		index := len(cancels)
+		cancels = append(cancels, cancel)
+		c.hedges.Add(1)
+		go func() {
+			defer c.hedges.Done()
+			resp, err := c.getFromRangeServer(rctx, path, expression)
+			results <- result{index: index, resp: resp, err: err}
+		}()
+	}
+
+	// abandon cancels every request other than the winner, and closes any
+	// responses that arrive after the winner's.
+	abandon := func(winner, outstanding int) {
+		for i, cancel := range cancels {
+			if i != winner {
+				cancel()
+			}
+		}
+		go func() {
+			for ; outstanding > 0; outstanding-- {
+				if r := <-results; r.err == nil {
+					_ = r.resp.Close()
+				}
+			}
+		}()
+	}
+
+	c.hedgeBudget.deposit()
+	launch()
+	launched, outstanding := 1, 1
+
+	timer := time.NewTimer(c.hedgeDelay)
+	defer timer.Stop()
+
+	var err error
+	for {
+		select {
+		case <-ctx.Done():
+			abandon(-1, outstanding)
+			return nil, ctx.Err()
+		case <-timer.C:
+			if launched <= c.maxHedges && c.hedgeBudget.withdraw() {
+				c.metrics.Hedge(expression)
+				launch()
+				launched++
+				outstanding++
+				timer.Reset(c.hedgeDelay)
+			}
+		case r := <-results:
+			outstanding--
+			if r.err == nil {
+				abandon(r.index, outstanding)
+				r.resp.ReadCloser = cancelReadCloser{ReadCloser: r.resp.ReadCloser, cancel: cancels[r.index]}
+				return r.resp, nil
+			}
+			cancels[r.index]()
+			err = r.err
+			if outstanding == 0 {
+				return nil, err
+			}
+		}
+	}
+}
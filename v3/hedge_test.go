@@ -0,0 +1,103 @@
+package gorange
+
+import (
+	"fmt"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// hedgeMetrics counts hedged requests, and requests observed after closed is
+// set.
+type hedgeMetrics struct {
+	NoopMetrics
+	closed int32 // accessed atomically
+	hedges int32 // accessed atomically
+	late   int32 // accessed atomically
+}
+
+func (m *hedgeMetrics) Hedge(string) {
+	atomic.AddInt32(&m.hedges, 1)
+}
+
+func (m *hedgeMetrics) Request(string, string, time.Duration, int, error) {
+	if atomic.LoadInt32(&m.closed) == 1 {
+		atomic.AddInt32(&m.late, 1)
+	}
+}
+
+// newHedgeServers returns a range server that does not respond until its
+// request is canceled, and a range server that responds immediately.
+func newHedgeServers() (*testRangeServer, *testRangeServer) {
+	slow := newTestRangeServer(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	})
+	fast := newTestRangeServer(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, r.URL.RawQuery)
+	})
+	return slow, fast
+}
+
+func TestHedgeUsesFirstResponse(t *testing.T) {
+	slow, fast := newHedgeServers()
+	defer slow.Close()
+	defer fast.Close()
+
+	metrics := new(hedgeMetrics)
+	querier, err := NewQuerier(&Configurator{
+		Servers:    []string{slow.URL, fast.URL},
+		HedgeDelay: 10 * time.Millisecond,
+		Metrics:    metrics,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = querier.Close() }()
+	client := querier.(*Client)
+
+	for i := 0; i < 4; i++ {
+		result, err := client.QueryResult("web1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result.Server != fast.URL {
+			t.Errorf("Server = %q; want %q", result.Server, fast.URL)
+		}
+	}
+	if got := atomic.LoadInt32(&metrics.hedges); got == 0 {
+		t.Errorf("no hedged requests sent")
+	}
+}
+
+func TestCloseWaitsForHedges(t *testing.T) {
+	slow, fast := newHedgeServers()
+	defer slow.Close()
+	defer fast.Close()
+
+	for i := 0; i < 20; i++ {
+		metrics := new(hedgeMetrics)
+		querier, err := NewQuerier(&Configurator{
+			Servers:                 []string{slow.URL, fast.URL},
+			CircuitBreakerThreshold: 3,
+			HedgeDelay:              5 * time.Millisecond,
+			Metrics:                 metrics,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = querier.Query("web1"); err != nil {
+			t.Fatal(err)
+		}
+		// When the query was hedged, the request to the slow range server is
+		// still being canceled.
+		if err = querier.Close(); err != nil {
+			t.Fatal(err)
+		}
+		atomic.StoreInt32(&metrics.closed, 1)
+		time.Sleep(5 * time.Millisecond)
+		if got := atomic.LoadInt32(&metrics.late); got > 0 {
+			t.Fatalf("hedged request still running after Close")
+		}
+	}
+}
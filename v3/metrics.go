@@ -0,0 +1,219 @@
+package gorange
+
+import (
+	"expvar"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// CacheStatus describes how a CachingClient satisfied a query.
+type CacheStatus int
+
+const (
+	// CacheMiss means the cache had no usable value, so the query was sent to
+	// the range servers.
+	CacheMiss CacheStatus = iota
+
+	// CacheHit means the query was satisfied by a fresh cached value.
+	CacheHit
+
+	// CacheStale means the query was satisfied by a stale cached value, while
+	// the value is asynchronously refreshed.
+	CacheStale
+)
+
+func (cs CacheStatus) String() string {
+	switch cs {
+	case CacheMiss:
+		return "miss"
+	case CacheHit:
+		return "hit"
+	case CacheStale:
+		return "stale"
+	default:
+		return "CacheStatus(" + strconv.Itoa(int(cs)) + ")"
+	}
+}
+
+// Metrics is the interface implemented by a structure that observes the
+// activity of a Querier.  Provide an instance as the Configurator's Metrics
+// field to receive these observations.  Methods are invoked synchronously from
+// the goroutines performing queries, possibly concurrently, so they must be
+// safe for concurrent use and ought to return quickly.
+type Metrics interface {
+	// Query is invoked once for each query sent to the range servers, after a
+	// response is received or all attempts fail, with the elapsed time, not
+	// including the time to read the response body.
+	Query(expression string, duration time.Duration, err error)
+
+	// Request is invoked for each HTTP request sent to a range server, with the
+	// server's endpoint, the HTTP method, the elapsed time, and the response
+	// status code, which is 0 when no response was received.
+	Request(server, method string, duration time.Duration, statusCode int, err error)
+
+	// Retry is invoked before a query is retried, with the number of the
+	// upcoming attempt and the error that caused the retry.
+	Retry(expression string, attempt int, err error)
+
+	// Hedge is invoked each time a hedged request for the query is sent to an
+	// additional range server, because the previous requests have not yet
+	// responded.
+	Hedge(expression string)
+
+	// MethodFallback is invoked when a range server rejects a request made with
+	// one HTTP method, and the request is resent using the other.
+	MethodFallback(server, from, to string)
+
+	// RangeException is invoked when a range server responds with a
+	// RangeException header.
+	RangeException(server, message string)
+
+	// Cache is invoked by a CachingClient for each query, with whether the
+	// query was satisfied by the cache.
+	Cache(expression string, status CacheStatus)
+
+	// Evict is invoked by a CachingClient when it evicts the least recently
+	// used expression from its cache to remain within its configured limits,
+	// with the approximate size of the evicted value in bytes.
+	Evict(expression string, size int)
+
+	// VersionCheck is invoked by a CachingClient each time it queries the
+	// `%version` key, with the version returned, whether the version changed
+	// since the previous check, and any error.
+	VersionCheck(version int64, changed bool, err error)
+
+	// Refresh is invoked by a CachingClient after refreshing its cache, with
+	// the number of keys scheduled to be refreshed and the number of keys
+	// dropped.
+	Refresh(refreshed, dropped int)
+}
+
+// NoopMetrics is a Metrics implementation that discards all observations.  It
+// is used when the Configurator does not specify Metrics.
+type NoopMetrics struct{}
+
+func (NoopMetrics) Query(string, time.Duration, error)                {}
+func (NoopMetrics) Request(string, string, time.Duration, int, error) {}
+func (NoopMetrics) Retry(string, int, error)                          {}
+func (NoopMetrics) Hedge(string)                                      {}
+func (NoopMetrics) MethodFallback(string, string, string)             {}
+func (NoopMetrics) RangeException(string, string)                     {}
+func (NoopMetrics) Cache(string, CacheStatus)                         {}
+func (NoopMetrics) Evict(string, int)                                 {}
+func (NoopMetrics) VersionCheck(int64, bool, error)                   {}
+func (NoopMetrics) Refresh(int, int)                                  {}
+
+// ExpvarMetrics is a Metrics implementation that publishes counters using the
+// expvar package, making them available at the `/debug/vars` endpoint of
+// programs that serve the default HTTP mux.  Per-server counters are
+// published in a nested map keyed by server endpoint.
+type ExpvarMetrics struct {
+	m       *expvar.Map
+	servers *expvar.Map
+	lock    sync.Mutex // serializes creation of per-server maps
+}
+
+// NewExpvarMetrics returns a new ExpvarMetrics that publishes its counters as
+// an expvar.Map using the specified name.  Like expvar.NewMap, it panics when
+// name is already in use.
+//
+//	metrics := gorange.NewExpvarMetrics("gorange")
+//	querier, err := gorange.NewQuerier(&gorange.Configurator{
+//		Metrics: metrics,
+//		Servers: []string{"range.example.com"},
+//	})
+func NewExpvarMetrics(name string) *ExpvarMetrics {
+	em := &ExpvarMetrics{m: expvar.NewMap(name), servers: new(expvar.Map).Init()}
+	em.m.Set("servers", em.servers)
+	return em
+}
+
+func (em *ExpvarMetrics) server(server string) *expvar.Map {
+	if v, ok := em.servers.Get(server).(*expvar.Map); ok {
+		return v
+	}
+	em.lock.Lock()
+	defer em.lock.Unlock()
+	if v, ok := em.servers.Get(server).(*expvar.Map); ok {
+		return v
+	}
+	v := new(expvar.Map).Init()
+	em.servers.Set(server, v)
+	return v
+}
+
+func (em *ExpvarMetrics) Query(_ string, duration time.Duration, err error) {
+	em.m.Add("queries", 1)
+	em.m.Add("queryNanoseconds", int64(duration))
+	if err != nil {
+		em.m.Add("queryErrors", 1)
+	}
+}
+
+func (em *ExpvarMetrics) Request(server, method string, duration time.Duration, statusCode int, err error) {
+	sm := em.server(server)
+	sm.Add("requests", 1)
+	sm.Add("requests"+method, 1)
+	sm.Add("latencyNanoseconds", int64(duration))
+	if err != nil {
+		sm.Add("errors", 1)
+	}
+	if statusCode > 0 {
+		sm.Add("status"+strconv.Itoa(statusCode), 1)
+	}
+}
+
+func (em *ExpvarMetrics) Retry(string, int, error) {
+	em.m.Add("retries", 1)
+}
+
+func (em *ExpvarMetrics) Hedge(string) {
+	em.m.Add("hedges", 1)
+}
+
+func (em *ExpvarMetrics) MethodFallback(server, from, to string) {
+	em.m.Add("methodFallbacks", 1)
+	em.server(server).Add("methodFallback"+from+"To"+to, 1)
+}
+
+func (em *ExpvarMetrics) RangeException(server, _ string) {
+	em.m.Add("rangeExceptions", 1)
+	em.server(server).Add("rangeExceptions", 1)
+}
+
+func (em *ExpvarMetrics) Cache(_ string, status CacheStatus) {
+	switch status {
+	case CacheHit:
+		em.m.Add("cacheHits", 1)
+	case CacheStale:
+		em.m.Add("cacheStale", 1)
+	default:
+		em.m.Add("cacheMisses", 1)
+	}
+}
+
+func (em *ExpvarMetrics) Evict(_ string, size int) {
+	em.m.Add("cacheEvictions", 1)
+	em.m.Add("cacheEvictedBytes", int64(size))
+}
+
+func (em *ExpvarMetrics) VersionCheck(version int64, changed bool, err error) {
+	em.m.Add("versionChecks", 1)
+	if err != nil {
+		em.m.Add("versionErrors", 1)
+		return
+	}
+	if changed {
+		em.m.Add("versionChanges", 1)
+	}
+	v := new(expvar.Int)
+	v.Set(version)
+	em.m.Set("version", v)
+}
+
+func (em *ExpvarMetrics) Refresh(refreshed, dropped int) {
+	em.m.Add("refreshes", 1)
+	em.m.Add("refreshedKeys", int64(refreshed))
+	em.m.Add("droppedKeys", int64(dropped))
+}
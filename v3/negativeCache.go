@@ -0,0 +1,112 @@
+package gorange
+
+import (
+	"fmt"
+	"time"
+)
+
+// DefaultNegativeCachePolicy is used when the Configurator does not specify a
+// NegativeCachePolicy.  It caches ErrRangeException responses, so the same
+// invalid query is not repeatedly sent to the range servers, and does not cache
+// other errors or treat empty results specially.
+var DefaultNegativeCachePolicy = NegativeCachePolicy{
+	RangeException: NegativeCacheRule{Stale: 1 * time.Minute, Expiry: 5 * time.Minute},
+}
+
+// NegativeCacheRule controls how long a CachingClient caches one class of
+// unsuccessful response.  A rule with a zero Expiry disables caching for its
+// class of response.
+type NegativeCacheRule struct {
+	// Stale is the duration after which a query for the cached response
+	// triggers an asynchronous lookup, while the cached response is returned.
+	// Must be less than Expiry.  Leave 0 to use Expiry.
+	Stale time.Duration
+
+	// Expiry is the duration after which the cached response is no longer
+	// served.  Leave 0 to not cache the class of response.
+	Expiry time.Duration
+}
+
+func (rule NegativeCacheRule) enabled() bool { return rule.Expiry > 0 }
+
+func (rule NegativeCacheRule) validate(name string) error {
+	if rule.Stale < 0 {
+		return fmt.Errorf("negative %s Stale: %s", name, rule.Stale)
+	}
+	if rule.Expiry < 0 {
+		return fmt.Errorf("negative %s Expiry: %s", name, rule.Expiry)
+	}
+	if rule.Stale > 0 && rule.Stale >= rule.Expiry {
+		return fmt.Errorf("%s Stale not less than Expiry: %s >= %s", name, rule.Stale, rule.Expiry)
+	}
+	return nil
+}
+
+// times returns the stale and expiry times for a response obtained now.
+func (rule NegativeCacheRule) times(now time.Time) (time.Time, time.Time) {
+	stale := rule.Stale
+	if stale == 0 {
+		stale = rule.Expiry
+	}
+	return now.Add(stale), now.Add(rule.Expiry)
+}
+
+// NegativeCachePolicy controls how a CachingClient caches errors and empty
+// results, with a rule for each class of response.  Errors are only cached
+// after every retry fails, and when the cache has no unexpired result for the
+// expression, which otherwise continues to be served.  Errors that are not
+// cached are returned to the caller, and the next query for the expression is
+// sent to the range servers.
+//
+//	querier, err := gorange.NewQuerier(&gorange.Configurator{
+//		NegativeCachePolicy: &gorange.NegativeCachePolicy{
+//			RangeException: gorange.NegativeCacheRule{Stale: time.Minute, Expiry: 5 * time.Minute},
+//			StatusNotOK:    gorange.NegativeCacheRule{Expiry: 30 * time.Second},
+//			Empty:          gorange.NegativeCacheRule{Stale: 10 * time.Second, Expiry: time.Hour},
+//		},
+//		Servers: []string{"range.example.com"},
+//		TTL:     time.Hour,
+//	})
+type NegativeCachePolicy struct {
+	// RangeException controls caching of ErrRangeException responses.
+	RangeException NegativeCacheRule
+
+	// StatusNotOK controls caching of ErrStatusNotOK responses, such as when
+	// the range server responds with 404 Not Found.
+	StatusNotOK NegativeCacheRule
+
+	// ParseException controls caching of ErrParseException responses.
+	ParseException NegativeCacheRule
+
+	// Empty controls caching of results that have no lines.  When disabled,
+	// empty results are cached like any other result.
+	Empty NegativeCacheRule
+}
+
+func (policy *NegativeCachePolicy) validate() error {
+	if err := policy.RangeException.validate("RangeException"); err != nil {
+		return err
+	}
+	if err := policy.StatusNotOK.validate("StatusNotOK"); err != nil {
+		return err
+	}
+	if err := policy.ParseException.validate("ParseException"); err != nil {
+		return err
+	}
+	return policy.Empty.validate("Empty")
+}
+
+// errorRule returns the rule for the class of the error.  Errors outside of the
+// classes covered by the policy are never cached.
+func (policy *NegativeCachePolicy) errorRule(err error) NegativeCacheRule {
+	switch err.(type) {
+	case ErrRangeException:
+		return policy.RangeException
+	case ErrStatusNotOK:
+		return policy.StatusNotOK
+	case ErrParseException:
+		return policy.ParseException
+	default:
+		return NegativeCacheRule{}
+	}
+}
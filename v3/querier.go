@@ -1,12 +1,13 @@
 package gorange
 
 import (
+	"context"
 	"fmt"
+	"io"
 	"net"
 	"net/http"
 	"net/url"
 	"sync"
-	"sync/atomic"
 	"time"
 )
 
@@ -33,14 +34,221 @@ type Querier interface {
 	Query(string) ([]string, error)
 }
 
+// ContextQuerier is the interface implemented by a Querier that can abort a
+// query when the provided context is done.  Both Client and CachingClient
+// implement this interface.
+//
+//	if cq, ok := querier.(gorange.ContextQuerier); ok {
+//		lines, err := cq.QueryContext(ctx, "%someQuery")
+//		// ...
+//	}
+type ContextQuerier interface {
+	QueryContext(context.Context, string) ([]string, error)
+}
+
+// Expander is the interface implemented by a Querier that can return the
+// compressed representation of a query result, as provided by the range
+// server's `/range/expand` endpoint.  Both Client and CachingClient implement
+// this interface.
+//
+//	if expander, ok := querier.(gorange.Expander); ok {
+//		result, err := expander.Expand("%someQuery")
+//		// ...
+//	}
+type Expander interface {
+	Expand(string) (string, error)
+	ExpandContext(context.Context, string) (string, error)
+}
+
+// RawQuerier is the interface implemented by a Querier that can return the
+// unbuffered response body of a query, as provided by the range server's
+// `/range/list` endpoint.  Both Client and CachingClient implement this
+// interface.
+type RawQuerier interface {
+	Raw(string) (io.ReadCloser, error)
+	RawContext(context.Context, string) (io.ReadCloser, error)
+}
+
+// EachQuerier is the interface implemented by a Querier that can invoke a
+// callback for each line of a query response as it is read, allowing very large
+// responses to be processed without buffering them in memory.  Both Client and
+// CachingClient implement this interface.
+type EachQuerier interface {
+	QueryEach(string, func(string) error) error
+	QueryEachContext(context.Context, string, func(string) error) error
+}
+
+// ResultQuerier is the interface implemented by a Querier that can return the
+// provenance of a query result along with its lines, such as which range server
+// provided it, and whether it was served from a cache.  Both Client and
+// CachingClient implement this interface.
+//
+//	if rq, ok := querier.(gorange.ResultQuerier); ok {
+//		result, err := rq.QueryResult("%someQuery")
+//		if err != nil {
+//			fmt.Fprintf(os.Stderr, "ERROR: %s", err)
+//			os.Exit(1)
+//		}
+//		log.Printf("%q from %s version %d: %v", result.Expression, result.Server, result.Version, result.Lines)
+//	}
+type ResultQuerier interface {
+	QueryResult(string) (*Result, error)
+	QueryResultContext(context.Context, string) (*Result, error)
+}
+
+// Verifier is the interface implemented by a Querier that can send a query to
+// several range servers and compare their responses, to detect range servers
+// whose data has diverged.  Both Client and CachingClient implement this
+// interface.
+type Verifier interface {
+	Verify(string) (*Verification, error)
+	VerifyContext(context.Context, string) (*Verification, error)
+}
+
+// Result is the response to a query along with its provenance.
+type Result struct {
+	// Expression is the query expression.
+	Expression string
+
+	// Lines is the list of strings the expression resolved to.
+	Lines []string
+
+	// Server is the address of the range server that provided the response.
+	// It is empty when the expression was expanded locally.
+	Server string
+
+	// Method is the HTTP method, either GET or PUT, of the request that
+	// obtained the response.  It is empty when the expression was expanded
+	// locally.
+	Method string
+
+	// Attempts is the number of attempts made to obtain the response,
+	// including the successful one, and is greater than 1 when the query was
+	// retried.  It is 0 when the expression was expanded locally.
+	Attempts int
+
+	// Latency is the time taken to obtain and read the response, including
+	// any retries.
+	Latency time.Duration
+
+	// Cache reports whether a CachingClient satisfied the query from its
+	// cache.  It is always CacheMiss for a Client.
+	Cache CacheStatus
+
+	// Age is the time elapsed since the response was obtained from the range
+	// server.  It is 0 unless the query was satisfied from a cache.
+	Age time.Duration
+
+	// Version is the `%version` of the range data when the response was
+	// obtained.  When MonotonicReads is enabled, it is the version the range
+	// server reported immediately before it provided the response.
+	// Otherwise, it is the version most recently observed by a CachingClient.
+	// It is 0 when the version is unknown, namely for a Client without
+	// MonotonicReads, or for a CachingClient that does not check the version
+	// because CheckVersionPeriodicity is 0.
+	Version int64
+
+	received  time.Time     // when the response was obtained
+	maxAge    time.Duration // from the response's Cache-Control header
+	hasMaxAge bool          // true when the response included a max-age
+}
+
+// clone returns a copy of the result, so callers cannot modify a shared one.
+func (r *Result) clone() *Result {
+	c := *r
+	c.Lines = append([]string(nil), r.Lines...)
+	return &c
+}
+
 // Configurator provides a way to list the range server addresses, and a way to
 // override defaults when creating new http.Client instances.
 type Configurator struct {
+	// HedgeBudgetRatio limits hedged requests to the given fraction of
+	// queries, so that a slow range server cannot cause every query to send
+	// additional requests, multiplying the load on the range servers.  For
+	// instance, 0.1 allows at most one hedged request for every ten queries,
+	// after an initial burst of DefaultRetryBudgetBurst hedged requests.  When
+	// the budget is depleted, queries wait for their original request.  Leave
+	// 0 to use DefaultHedgeBudgetRatio.  Ignored unless HedgeDelay is provided.
+	HedgeBudgetRatio float64
+
+	// HedgeDelay enables hedged requests to reduce tail latency.  When a range
+	// server has not responded to a query within HedgeDelay, the same query is
+	// sent to another range server, and the first successful response is used
+	// while the other requests are canceled.  Leave 0 to disable hedging.
+	HedgeDelay time.Duration
+
 	// HTTPClient allows the caller to specify a specially configured
 	// http.Client instance to use for all queries.  When none is provided, a
 	// client will be created using the default timeouts.
 	HTTPClient *http.Client
 
+	// CircuitBreakerThreshold is the number of consecutive failed requests to a
+	// range server after which that server is ejected, and no longer receives
+	// queries unless every range server is ejected.  Network errors and 5xx
+	// status codes count as failures, but RangeException responses do not.
+	// Ejected servers are probed in the background using the `%version` query,
+	// and returned to service once a probe succeeds.  Leave 0 to disable
+	// circuit breaking.
+	CircuitBreakerThreshold int
+
+	// CircuitBreakerCooldown is the amount of time an ejected range server
+	// waits before each background probe.  Leave 0 to use
+	// DefaultCircuitBreakerCooldown.
+	CircuitBreakerCooldown time.Duration
+
+	// ExpandLocally directs the Querier to expand self-contained expressions,
+	// such as `web{01-40}.dc1,db[1-4].dc2`, without sending them to a range
+	// server.  An expression is self-contained when it is built only from
+	// literal host names, sequences, braces, and the union, difference, and
+	// intersection operators.  Expressions that reference server-side data, such
+	// as cluster lookups, regular expressions, or function calls, as well as
+	// expressions that cannot be parsed locally, are still sent to the range
+	// servers.
+	ExpandLocally bool
+
+	// MonotonicReads prevents queries from observing data that goes backwards
+	// when the range servers reload their data at slightly different times.
+	// Before each query is sent to a range server, that range server's
+	// `%version` is checked, and when it is older than the highest version
+	// observed from any range server, the query is sent to another range
+	// server instead, or fails with ErrStaleServers when no range server is
+	// current.  This costs an additional `%version` request for each query
+	// sent to the range servers.  The `%version` of each range server is also
+	// periodically checked, so that queries avoid range servers already known
+	// to be behind.  Range servers that do not support `%version` receive
+	// queries regardless.
+	MonotonicReads bool
+
+	// MonotonicReadsPeriodicity is the amount of time between checks of the
+	// `%version` of each range server.  Leave 0 to use
+	// DefaultMonotonicReadsPeriodicity.  Ignored unless MonotonicReads is
+	// enabled.
+	MonotonicReadsPeriodicity time.Duration
+
+	// MaxHedges is the maximum number of hedged requests sent for each query
+	// attempt in addition to the original request, which limits the extra load
+	// hedging places on the range servers.  Leave 0 to send at most 1 hedged
+	// request.  Ignored unless HedgeDelay is provided.
+	MaxHedges int
+
+	// MaxLineLength is the maximum length in bytes of a single line of a query
+	// response.  Leave 0 to use bufio.MaxScanTokenSize, which is 64 KiB.
+	MaxLineLength int
+
+	// MaxResults is the maximum number of lines a query response may have.
+	// Queries whose responses have more lines, including expressions expanded
+	// locally, return ErrTooManyResults.  Leave 0 to not limit the number of
+	// lines.
+	MaxResults int
+
+	// Metrics receives observations of the Querier's activity, such as
+	// queries, retries, per-server request latencies and status codes, and
+	// cache hits and misses.  Leave nil to discard observations.  See
+	// NewExpvarMetrics for an implementation that publishes counters using the
+	// expvar package.
+	Metrics Metrics
+
 	// RetryCallback is predicate function that tests whether query should be
 	// retried for a given error.  Leave nil to retry all errors.
 	RetryCallback func(error) bool
@@ -50,12 +258,97 @@ type Configurator struct {
 	RetryCount int
 
 	// RetryPause is the amount of time to wait before retrying the query.
+	// Cannot be used with RetryPolicy.
 	RetryPause time.Duration
 
+	// RetryPolicy controls exponential backoff and jitter of the pause before
+	// retrying a query, an overall deadline for each query, and a retry budget
+	// that limits retries to a fraction of queries.  Leave nil to wait
+	// RetryPause before each retry.  When RetryPolicy is provided, RetryPause
+	// must be 0.
+	RetryPolicy *RetryPolicy
+
+	// SRVName is an optional DNS SRV name, such as `_range._tcp.example.com`,
+	// whose records list the range servers, which will be queried using HTTP.
+	// Requests are sent to the range servers with the lowest priority, in
+	// proportion to their weights, and fail over to range servers with the
+	// next lowest priority only when none of them is available, in the same
+	// way as NewLocalitySelector.  The name is resolved every
+	// ServerResolverPeriodicity, and the range servers are replaced when its
+	// records change.  When resolution fails, or returns no records, the last
+	// known range servers are used.  When neither Servers nor Selector is
+	// provided, it is also resolved by NewQuerier to obtain the initial range
+	// servers, and NewQuerier fails when resolution fails.  Cannot be used with
+	// ServerResolver.
+	SRVName string
+
+	// SRVResolver resolves SRVName.  Leave nil to use net.DefaultResolver.
+	SRVResolver SRVResolver
+
+	// Selector chooses which range server receives each request.  Leave nil to
+	// send requests to the range servers listed in Servers in round robin
+	// order.  See NewWeightedSelector, NewLatencySelector, and
+	// NewLocalitySelector for alternatives.  When Selector is provided, Servers
+	// must be empty, and the range server addresses returned by the Selector
+	// may use any form Servers accepts.
+	Selector Selector
+
+	// ServerResolver is an optional callback that returns the range server
+	// addresses, for example by re-reading a file or resolving DNS records.
+	// When provided, it is invoked every ServerResolverPeriodicity, and the
+	// range servers are replaced in the same way as SetServers when its result
+	// changes.  When it returns an error, or an invalid address, the range
+	// servers are left unchanged.  When neither Servers nor Selector is
+	// provided, it is also invoked by NewQuerier to obtain the initial range
+	// servers, and NewQuerier fails when it returns an error.
+	ServerResolver func() ([]string, error)
+
+	// ServerResolverPeriodicity is the duration between invocations of
+	// ServerResolver, or resolutions of SRVName.  Leave 0 to use
+	// DefaultServerResolverPeriodicity.
+	ServerResolverPeriodicity time.Duration
+
 	// Servers is slice of range server address strings.  Must contain at least
-	// one string.
+	// one string, unless Selector, ServerResolver, or SRVName is provided.
+	// Each string is either a bare network address, such as
+	// `range.example.com` or `range.example.com:8080`, which will be queried
+	// using HTTP, or a full URL, such as `https://range.example.com:8443/api`,
+	// whose scheme, host, port, and path prefix will be used to build the URL
+	// for each query.  A URL with the `unix` scheme, such as
+	// `unix:///var/run/range.sock`, specifies the path of a Unix domain socket
+	// on which a range server, such as a co-located range proxy, listens for
+	// HTTP requests.  Only the `http`, `https`, and `unix` schemes are
+	// supported.  Unix domain sockets require the http.Client created when
+	// HTTPClient is not provided.
 	Servers []string
 
+	// VerifyServers is the number of range servers, selected at random, to
+	// which Verify sends each query.  Leave 0 to send queries to every range
+	// server.
+	VerifyServers int
+
+	// TLSCAFile is the path to a file containing one or more PEM encoded
+	// certificate authority certificates used to verify the certificates
+	// presented by range servers.  Leave blank to use the host's root
+	// certificate authorities.  Must be blank when HTTPClient is provided.
+	TLSCAFile string
+
+	// TLSCertFile is the path to a file containing the PEM encoded client
+	// certificate presented to range servers that require mutual TLS.  When
+	// provided, TLSKeyFile must also be provided.  Must be blank when
+	// HTTPClient is provided.
+	TLSCertFile string
+
+	// TLSKeyFile is the path to a file containing the PEM encoded private key
+	// for the client certificate specified by TLSCertFile.  Must be blank when
+	// HTTPClient is provided.
+	TLSKeyFile string
+
+	// TLSServerName overrides the host name used to verify the certificates
+	// presented by range servers.  Leave blank to use the host name from each
+	// server address.  Must be blank when HTTPClient is provided.
+	TLSServerName string
+
 	// TTL is duration of time to cache query responses. Leave 0 to not cache
 	// responses.  When a value is older than its TTL, it becomes stale.  When a
 	// key is queried for a value that is stale, an asynchronous routine
@@ -89,6 +382,78 @@ type Configurator struct {
 	// will be refreshed.  It makes no sense for CheckVersionPeriodicity to be a
 	// non-zero value when TTL and TTE are both zero-values.
 	CheckVersionPeriodicity time.Duration
+
+	// CacheFile is the path of a file in which the CachingClient persists its
+	// cached results, their stale and expiry times, the times they were last
+	// requested, and the last known `%version`.  The file is written when the
+	// Querier is closed, and every CacheFilePeriodicity, by atomically
+	// replacing the previous file.  When the file exists at startup, its
+	// results are loaded and served as stale values until they are
+	// successfully refreshed, so a program that restarts while the range
+	// servers are unreachable can still resolve the queries it previously
+	// made.  A file that is truncated, corrupt, or written by an incompatible
+	// version of this library is ignored.  Leave empty to not persist the
+	// cache.  Ignored unless TTL, TTE, or CheckVersionPeriodicity is provided.
+	CacheFile string
+
+	// CacheFilePeriodicity is the amount of time between writes of the
+	// CacheFile.  Leave 0 to write the CacheFile only when the Querier is
+	// closed.
+	CacheFilePeriodicity time.Duration
+
+	// MaxCacheBytes limits the approximate memory used by a CachingClient's
+	// cache, counting the string data of each cached expression and its
+	// lines.  When a response would cause the cache to exceed the limit, the
+	// least recently queried expressions are evicted.  A single response
+	// larger than the limit is still cached, on its own.  Watched expressions
+	// are never evicted, even when that exceeds the limit.  Leave 0 to not
+	// limit the cache by size.
+	MaxCacheBytes int
+
+	// MaxCacheEntries limits the number of expressions held in a
+	// CachingClient's cache.  When a response would cause the cache to exceed
+	// the limit, the least recently queried expression that is not watched is
+	// evicted.  Leave 0 to not limit the number of expressions.
+	MaxCacheEntries int
+
+	// CacheDurations returns the TTL and TTE a CachingClient uses for the
+	// response to the expression, allowing expressions that change often to
+	// be refreshed sooner than expressions that rarely change.  A zero TTL
+	// means the response never goes stale, and a zero TTE means it never
+	// expires.  Return zero for both to use the configured TTL and TTE.
+	// Empty results cached by the NegativeCachePolicy use its durations
+	// instead.  Leave nil to use the configured TTL and TTE for every
+	// expression.  Requires TTL, TTE, or CheckVersionPeriodicity.
+	//
+	//	CacheDurations: func(expression string) (time.Duration, time.Duration) {
+	//		switch expression {
+	//		case "%oncall":
+	//			return 5 * time.Minute, time.Hour
+	//		case "%datacenters":
+	//			return 24 * time.Hour, 0
+	//		default:
+	//			return 0, 0
+	//		}
+	//	},
+	CacheDurations func(expression string) (ttl, tte time.Duration)
+
+	// HonorCacheControl directs a CachingClient to use the max-age directive of
+	// a response's Cache-Control header, when present, as the TTL of that
+	// response, and to extend its TTE to at least the max-age.  Durations
+	// returned by CacheDurations take precedence over max-age.  Requires TTL,
+	// TTE, or CheckVersionPeriodicity.
+	HonorCacheControl bool
+
+	// NegativeCachePolicy controls how long a CachingClient caches each class
+	// of error, such as ErrRangeException or ErrStatusNotOK, and whether it
+	// caches empty results with their own durations.  Leave nil to use
+	// DefaultNegativeCachePolicy.
+	NegativeCachePolicy *NegativeCachePolicy
+
+	// WarmConcurrency is the maximum number of queries a CachingClient sends
+	// concurrently from its Warm method.  Leave 0 to use
+	// DefaultWarmConcurrency.
+	WarmConcurrency int
 }
 
 // NewQuerier returns a new instance that sends queries to one or more range
@@ -119,9 +484,74 @@ type Configurator struct {
 //	}
 func NewQuerier(config *Configurator) (Querier, error) {
 	// Fields that relate to all Querier instances.
-	rrs, err := newRoundRobinStrings(config.Servers)
+	if config.ServerResolverPeriodicity < 0 {
+		return nil, fmt.Errorf("cannot create Querier with negative ServerResolverPeriodicity: %s", config.ServerResolverPeriodicity)
+	}
+	var resolve resolveFunc
+	switch {
+	case config.SRVName != "" && config.ServerResolver != nil:
+		return nil, fmt.Errorf("cannot create Querier with both SRVName and ServerResolver")
+	case config.SRVName != "":
+		resolver := config.SRVResolver
+		if resolver == nil {
+			resolver = net.DefaultResolver
+		}
+		resolve = resolveSRV(resolver, config.SRVName)
+	case config.ServerResolver != nil:
+		resolve = resolveServers(config.ServerResolver)
+	case config.SRVResolver != nil:
+		return nil, fmt.Errorf("cannot create Querier with SRVResolver but without SRVName")
+	}
+	var resolved string // describes the range servers obtained from resolve
+	selector := config.Selector
+	if selector == nil {
+		if len(config.Servers) == 0 && resolve != nil {
+			key, rs, err := resolve()
+			if err != nil {
+				return nil, fmt.Errorf("cannot create Querier without resolving range servers: %s", err)
+			}
+			resolved, selector = key, rs
+		} else {
+			rrs, err := newRoundRobinStrings(config.Servers)
+			if err != nil {
+				return nil, fmt.Errorf("cannot create Querier without at least one range server address")
+			}
+			selector = rrs
+		}
+	} else if len(config.Servers) > 0 {
+		return nil, fmt.Errorf("cannot create Querier with both Servers and Selector")
+	}
+	set, err := newServerSet(selector)
 	if err != nil {
-		return nil, fmt.Errorf("cannot create Querier without at least one range server address")
+		return nil, fmt.Errorf("cannot create Querier %s", err)
+	}
+	servers := selector.Servers()
+	if config.CircuitBreakerThreshold < 0 {
+		return nil, fmt.Errorf("cannot create Querier with negative CircuitBreakerThreshold: %d", config.CircuitBreakerThreshold)
+	}
+	if config.CircuitBreakerCooldown < 0 {
+		return nil, fmt.Errorf("cannot create Querier with negative CircuitBreakerCooldown: %s", config.CircuitBreakerCooldown)
+	}
+	if config.HedgeDelay < 0 {
+		return nil, fmt.Errorf("cannot create Querier with negative HedgeDelay: %s", config.HedgeDelay)
+	}
+	if config.HedgeBudgetRatio < 0 {
+		return nil, fmt.Errorf("cannot create Querier with negative HedgeBudgetRatio: %g", config.HedgeBudgetRatio)
+	}
+	if config.MaxHedges < 0 {
+		return nil, fmt.Errorf("cannot create Querier with negative MaxHedges: %d", config.MaxHedges)
+	}
+	if config.MonotonicReadsPeriodicity < 0 {
+		return nil, fmt.Errorf("cannot create Querier with negative MonotonicReadsPeriodicity: %s", config.MonotonicReadsPeriodicity)
+	}
+	if config.MaxLineLength < 0 {
+		return nil, fmt.Errorf("cannot create Querier with negative MaxLineLength: %d", config.MaxLineLength)
+	}
+	if config.MaxResults < 0 {
+		return nil, fmt.Errorf("cannot create Querier with negative MaxResults: %d", config.MaxResults)
+	}
+	if config.VerifyServers < 0 {
+		return nil, fmt.Errorf("cannot create Querier with negative VerifyServers: %d", config.VerifyServers)
 	}
 	if config.RetryCount < 0 {
 		return nil, fmt.Errorf("cannot create Querier with negative RetryCount: %d", config.RetryCount)
@@ -130,6 +560,17 @@ func NewQuerier(config *Configurator) (Querier, error) {
 		return nil, fmt.Errorf("cannot create Querier with negative RetryPause: %s", config.RetryPause)
 	}
 
+	retryPolicy := RetryPolicy{InitialBackoff: config.RetryPause, Multiplier: 1}
+	if config.RetryPolicy != nil {
+		if config.RetryPause != 0 {
+			return nil, fmt.Errorf("cannot create Querier with both RetryPause and RetryPolicy")
+		}
+		if err := config.RetryPolicy.validate(); err != nil {
+			return nil, fmt.Errorf("cannot create Querier with invalid RetryPolicy: %s", err)
+		}
+		retryPolicy = *config.RetryPolicy
+	}
+
 	// Fields that relate to CachingClient instances.
 	if config.CheckVersionPeriodicity < 0 {
 		return nil, fmt.Errorf("cannot create Querier with negative CheckVersionPeriodicity duration: %v", config.CheckVersionPeriodicity)
@@ -140,10 +581,46 @@ func NewQuerier(config *Configurator) (Querier, error) {
 	if config.TTE < 0 {
 		return nil, fmt.Errorf("cannot create Querier with negative TTE: %v", config.TTE)
 	}
+	if config.CacheFilePeriodicity < 0 {
+		return nil, fmt.Errorf("cannot create Querier with negative CacheFilePeriodicity: %v", config.CacheFilePeriodicity)
+	}
+	if config.MaxCacheBytes < 0 {
+		return nil, fmt.Errorf("cannot create Querier with negative MaxCacheBytes: %d", config.MaxCacheBytes)
+	}
+	if config.MaxCacheEntries < 0 {
+		return nil, fmt.Errorf("cannot create Querier with negative MaxCacheEntries: %d", config.MaxCacheEntries)
+	}
+	caching := config.CheckVersionPeriodicity > 0 || config.TTE > 0 || config.TTL > 0
+	if config.CacheDurations != nil && !caching {
+		return nil, fmt.Errorf("cannot create Querier with CacheDurations but without TTL, TTE, or CheckVersionPeriodicity")
+	}
+	if config.HonorCacheControl && !caching {
+		return nil, fmt.Errorf("cannot create Querier with HonorCacheControl but without TTL, TTE, or CheckVersionPeriodicity")
+	}
+	negative := DefaultNegativeCachePolicy
+	if config.NegativeCachePolicy != nil {
+		if err := config.NegativeCachePolicy.validate(); err != nil {
+			return nil, fmt.Errorf("cannot create Querier with invalid NegativeCachePolicy: %s", err)
+		}
+		negative = *config.NegativeCachePolicy
+	}
+	if config.WarmConcurrency < 0 {
+		return nil, fmt.Errorf("cannot create Querier with negative WarmConcurrency: %d", config.WarmConcurrency)
+	}
+
+	tlsConfig, err := newTLSConfig(config)
+	if err != nil {
+		return nil, fmt.Errorf("cannot create Querier with invalid TLS options: %s", err)
+	}
 
-	retryCallback := config.RetryCallback
-	if retryCallback == nil {
-		retryCallback = makeRetryCallback(len(config.Servers))
+	maxHedges := config.MaxHedges
+	if maxHedges == 0 {
+		maxHedges = 1
+	}
+
+	metrics := config.Metrics
+	if metrics == nil {
+		metrics = NoopMetrics{}
 	}
 
 	httpClient := config.HTTPClient
@@ -155,54 +632,147 @@ func NewQuerier(config *Configurator) (Querier, error) {
 			Timeout: time.Duration(DefaultQueryTimeout),
 
 			Transport: &http.Transport{
-				Dial: (&net.Dialer{
+				DialContext: unixDialContext(&net.Dialer{
 					Timeout:   DefaultDialTimeout,
 					KeepAlive: DefaultDialKeepAlive,
-				}).Dial,
+				}),
 				MaxIdleConnsPerHost: int(DefaultMaxIdleConnsPerHost),
+				TLSClientConfig:     tlsConfig,
 			},
 		}
+	} else if tlsConfig != nil {
+		return nil, fmt.Errorf("cannot create Querier with both HTTPClient and TLS options")
 	}
 
 	client := &Client{
+		expandLocally: config.ExpandLocally,
+		hedgeDelay:    config.HedgeDelay,
 		httpClient:    httpClient,
-		retryCallback: retryCallback,
+		maxHedges:     maxHedges,
+		maxLineLength: config.MaxLineLength,
+		maxResults:    config.MaxResults,
+		metrics:       metrics,
+		retryCallback: config.RetryCallback,
 		retryCount:    config.RetryCount,
-		retryPause:    config.RetryPause,
-		servers:       rrs,
+		retryPolicy:   retryPolicy,
+		servers:       set,
+		verifyCount:   config.VerifyServers,
+	}
+	if client.retryCallback == nil {
+		client.retryCallback = makeRetryCallback(client.serverCount)
 	}
 
-	if config.CheckVersionPeriodicity == 0 && config.TTE == 0 && config.TTL == 0 {
+	if config.HedgeDelay > 0 {
+		ratio := config.HedgeBudgetRatio
+		if ratio == 0 {
+			ratio = DefaultHedgeBudgetRatio
+		}
+		client.hedgeBudget = newRetryBudget(ratio, 0)
+	}
+
+	if retryPolicy.BudgetRatio > 0 {
+		client.retryBudget = newRetryBudget(retryPolicy.BudgetRatio, retryPolicy.BudgetBurst)
+	}
+
+	if config.CircuitBreakerThreshold > 0 {
+		cooldown := config.CircuitBreakerCooldown
+		if cooldown == 0 {
+			cooldown = DefaultCircuitBreakerCooldown
+		}
+		client.health = newHealthTracker(servers, config.CircuitBreakerThreshold, cooldown, client.probeServer)
+	}
+
+	if config.MonotonicReads {
+		periodicity := config.MonotonicReadsPeriodicity
+		if periodicity == 0 {
+			periodicity = DefaultMonotonicReadsPeriodicity
+		}
+		client.versions = newVersionTracker(servers, periodicity, client.serverVersion)
+	}
+
+	if resolve != nil {
+		periodicity := config.ServerResolverPeriodicity
+		if periodicity == 0 {
+			periodicity = DefaultServerResolverPeriodicity
+		}
+		client.resolver = newServerResolver(client, periodicity, resolve, resolved)
+	}
+
+	if !caching {
 		return client, nil
 	}
 
+	warmConcurrency := config.WarmConcurrency
+	if warmConcurrency == 0 {
+		warmConcurrency = DefaultWarmConcurrency
+	}
+
 	ccc := cachingClientConfig{
+		cacheDurations:          config.CacheDurations,
+		cacheFile:               config.CacheFile,
+		cacheFilePeriodicity:    config.CacheFilePeriodicity,
 		checkVersionPeriodicity: config.CheckVersionPeriodicity,
 		client:                  client,
 		expiry:                  config.TTE,
+		honorCacheControl:       config.HonorCacheControl,
+		maxBytes:                config.MaxCacheBytes,
+		maxEntries:              config.MaxCacheEntries,
+		negative:                negative,
 		stale:                   config.TTL,
+		warmConcurrency:         warmConcurrency,
 	}
 
-	return newCachingClient(ccc)
+	cc, err := newCachingClient(ccc)
+	if err != nil {
+		_ = client.Close()
+		return nil, err
+	}
+	return cc, nil
 }
 
 // MultiQuery sends each query out in parallel and returns the set union of the
 // responses from each query.
 func MultiQuery(querier Querier, queries []string) ([]string, error) {
+	return MultiQueryContext(context.Background(), querier, queries)
+}
+
+// MultiQueryContext sends each query out in parallel and returns the set union
+// of the responses from each query.  When any query returns an error, or when
+// the provided context is done, the remaining in-flight queries are canceled.
+// When the Querier does not implement ContextQuerier, its queries cannot be
+// canceled, but MultiQueryContext still returns once they complete.
+func MultiQueryContext(ctx context.Context, querier Querier, queries []string) ([]string, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	query := func(ctx context.Context, expression string) ([]string, error) {
+		return querier.Query(expression)
+	}
+	if cq, ok := querier.(ContextQuerier); ok {
+		query = cq.QueryContext
+	}
+
 	var wg sync.WaitGroup
-	var wgErr atomic.Value // error
 	wg.Add(len(queries))
 
 	results := make(map[string]struct{})
-	var resultsLock sync.Mutex
+	var firstErr error
+	var resultsLock sync.Mutex // protects results and firstErr
 
 	for _, q := range queries {
-		go func(query string) {
+		go func(expression string) {
 			defer wg.Done()
 
-			lines, err := querier.Query(query)
+			lines, err := query(ctx, expression)
 			if err != nil {
-				wgErr.Store(err)
+				resultsLock.Lock()
+				if firstErr == nil {
+					// Only the first error is returned, because queries that
+					// fail after it are usually failing due to the cancel.
+					firstErr = err
+				}
+				resultsLock.Unlock()
+				cancel() // no point in waiting for remaining queries
 				return
 			}
 
@@ -215,8 +785,8 @@ func MultiQuery(querier Querier, queries []string) ([]string, error) {
 	}
 	wg.Wait()
 
-	if v := wgErr.Load(); v != nil {
-		return nil, v.(error)
+	if firstErr != nil {
+		return nil, firstErr
 	}
 
 	values := make([]string, 0, len(results)) // NOTE: len 0 for append
@@ -249,7 +819,9 @@ func isTimeout(err error) bool {
 	return ok && t.Timeout()
 }
 
-func makeRetryCallback(count int) func(error) bool {
+// makeRetryCallback returns the default retry callback, which invokes count for
+// the current number of range servers each time it is asked about an error.
+func makeRetryCallback(count func() int) func(error) bool {
 	return func(err error) bool {
 		// Because some DNSError errors can be temporary or timeout, most efficient to check
 		// whether those conditions are true first.
@@ -264,7 +836,7 @@ func makeRetryCallback(count int) func(error) bool {
 					// "no such host": This query may be retried either if there
 					// are more servers in the list of servers, or if the DNS
 					// lookup resulted in a timeout.
-					return count > 1
+					return count() > 1
 				}
 			}
 		}
@@ -0,0 +1,34 @@
+package gorange
+
+import (
+	"context"
+	"testing"
+)
+
+// fakeContextQuerier fails queries for "bad", and blocks other queries until
+// their context is done.
+type fakeContextQuerier struct{}
+
+func (fakeContextQuerier) Close() error { return nil }
+
+func (f fakeContextQuerier) Query(expression string) ([]string, error) {
+	return f.QueryContext(context.Background(), expression)
+}
+
+func (fakeContextQuerier) QueryContext(ctx context.Context, expression string) ([]string, error) {
+	if expression == "bad" {
+		return nil, ErrRangeException{Message: "bad"}
+	}
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func TestMultiQueryContextReturnsFirstError(t *testing.T) {
+	queries := []string{"a", "b", "bad", "c", "d", "e", "f", "g"}
+	for i := 0; i < 100; i++ {
+		_, err := MultiQueryContext(context.Background(), fakeContextQuerier{}, queries)
+		if _, ok := err.(ErrRangeException); !ok {
+			t.Fatalf("MultiQueryContext error = %v; want ErrRangeException", err)
+		}
+	}
+}
@@ -0,0 +1,205 @@
+package gorange
+
+import (
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// DefaultRetryMultiplier is used when a RetryPolicy does not specify a
+// Multiplier, doubling the backoff after each retry.
+const DefaultRetryMultiplier = 2
+
+// DefaultRetryBudgetBurst is used when a RetryPolicy specifies a BudgetRatio
+// but no BudgetBurst, to control how many retries may be issued in a burst
+// before the retry budget is depleted.
+const DefaultRetryBudgetBurst = 10
+
+// Jitter selects how a RetryPolicy randomizes the pause before each retry, so
+// that many clients that observe the same failure do not retry in lockstep.
+type Jitter int
+
+const (
+	// NoJitter pauses for exactly the computed backoff.
+	NoJitter Jitter = iota
+
+	// FullJitter pauses for a random duration between zero and the computed
+	// backoff.
+	FullJitter
+
+	// DecorrelatedJitter pauses for a random duration between InitialBackoff
+	// and three times the previous pause, capped at MaxBackoff.
+	DecorrelatedJitter
+)
+
+func (j Jitter) String() string {
+	switch j {
+	case NoJitter:
+		return "none"
+	case FullJitter:
+		return "full"
+	case DecorrelatedJitter:
+		return "decorrelated"
+	default:
+		return fmt.Sprintf("Jitter(%d)", int(j))
+	}
+}
+
+// RetryPolicy controls how long a Client pauses before retrying a failed query,
+// and how many retries it may issue relative to the number of queries.  The
+// number of retries for each query is still limited by the Configurator's
+// RetryCount.
+//
+//	querier, err := gorange.NewQuerier(&gorange.Configurator{
+//		RetryCount: 3,
+//		RetryPolicy: &gorange.RetryPolicy{
+//			InitialBackoff: 100 * time.Millisecond,
+//			MaxBackoff:     5 * time.Second,
+//			Jitter:         gorange.FullJitter,
+//			Deadline:       30 * time.Second,
+//			BudgetRatio:    0.1,
+//		},
+//		Servers: []string{"range1.example.com", "range2.example.com"},
+//	})
+type RetryPolicy struct {
+	// InitialBackoff is the pause before the first retry.
+	InitialBackoff time.Duration
+
+	// MaxBackoff caps the pause before any retry.  Leave 0 to not cap the
+	// pause.
+	MaxBackoff time.Duration
+
+	// Multiplier is the factor by which the backoff grows after each retry.
+	// Leave 0 to use DefaultRetryMultiplier.  Use 1 to pause for the same
+	// duration before each retry.
+	Multiplier float64
+
+	// Jitter selects how the pause before each retry is randomized.
+	Jitter Jitter
+
+	// Deadline limits the total time spent on a query, including all of its
+	// retries and reading the response.  No retry is issued when its pause
+	// would end after the deadline.  Leave 0 to limit a query only by its
+	// context and RetryCount.
+	Deadline time.Duration
+
+	// BudgetRatio enables a retry budget, which limits retries to the given
+	// fraction of queries, so that retries cannot amplify the load on range
+	// servers during an outage.  For instance, 0.1 allows at most one retry
+	// for every ten queries, after any initial burst.  Leave 0 to not limit
+	// retries by a budget.
+	BudgetRatio float64
+
+	// BudgetBurst is the number of retries that may be issued before the retry
+	// budget is depleted, and the maximum number of retries the budget will
+	// accumulate.  Leave 0 to use DefaultRetryBudgetBurst.  Ignored unless
+	// BudgetRatio is provided.
+	BudgetBurst int
+}
+
+func (rp *RetryPolicy) validate() error {
+	if rp.InitialBackoff < 0 {
+		return fmt.Errorf("negative InitialBackoff: %s", rp.InitialBackoff)
+	}
+	if rp.MaxBackoff < 0 {
+		return fmt.Errorf("negative MaxBackoff: %s", rp.MaxBackoff)
+	}
+	if rp.Multiplier != 0 && rp.Multiplier < 1 {
+		return fmt.Errorf("Multiplier less than 1: %g", rp.Multiplier)
+	}
+	if rp.Jitter < NoJitter || rp.Jitter > DecorrelatedJitter {
+		return fmt.Errorf("unknown Jitter: %s", rp.Jitter)
+	}
+	if rp.Deadline < 0 {
+		return fmt.Errorf("negative Deadline: %s", rp.Deadline)
+	}
+	if rp.BudgetRatio < 0 {
+		return fmt.Errorf("negative BudgetRatio: %g", rp.BudgetRatio)
+	}
+	if rp.BudgetBurst < 0 {
+		return fmt.Errorf("negative BudgetBurst: %d", rp.BudgetBurst)
+	}
+	return nil
+}
+
+// backoff returns the pause before the specified retry, numbered from 1, given
+// the pause before the previous retry.
+func (rp *RetryPolicy) backoff(attempt int, previous time.Duration) time.Duration {
+	if rp.InitialBackoff <= 0 {
+		return 0
+	}
+
+	var pause time.Duration
+	if rp.Jitter == DecorrelatedJitter {
+		high := 3 * previous
+		if high <= rp.InitialBackoff {
+			pause = rp.InitialBackoff
+		} else {
+			pause = rp.InitialBackoff + time.Duration(rand.Int63n(int64(high-rp.InitialBackoff)))
+		}
+	} else {
+		multiplier := rp.Multiplier
+		if multiplier == 0 {
+			multiplier = DefaultRetryMultiplier
+		}
+		b := float64(rp.InitialBackoff)
+		for i := 1; i < attempt; i++ {
+			b *= multiplier
+			if rp.MaxBackoff > 0 && b >= float64(rp.MaxBackoff) {
+				break
+			}
+		}
+		if b > float64(1<<62) {
+			b = float64(1 << 62) // prevent overflow when not capped
+		}
+		pause = time.Duration(b)
+	}
+
+	if rp.MaxBackoff > 0 && pause > rp.MaxBackoff {
+		pause = rp.MaxBackoff
+	}
+	if rp.Jitter == FullJitter && pause > 0 {
+		pause = time.Duration(rand.Int63n(int64(pause) + 1))
+	}
+	return pause
+}
+
+// retryBudget is a token bucket that accrues a fraction of a token for each
+// query, and spends a whole token for each retry.  It also limits hedged
+// requests, spending a token for each.
+type retryBudget struct {
+	ratio float64
+	burst float64
+
+	lock   sync.Mutex
+	tokens float64
+}
+
+func newRetryBudget(ratio float64, burst int) *retryBudget {
+	if burst == 0 {
+		burst = DefaultRetryBudgetBurst
+	}
+	return &retryBudget{ratio: ratio, burst: float64(burst), tokens: float64(burst)}
+}
+
+// deposit accrues the fraction of a retry earned by a query.
+func (rb *retryBudget) deposit() {
+	rb.lock.Lock()
+	rb.tokens += rb.ratio
+	if rb.tokens > rb.burst {
+		rb.tokens = rb.burst
+	}
+	rb.lock.Unlock()
+}
+
+// withdraw returns true and spends a token when the budget allows a retry.
+func (rb *retryBudget) withdraw() bool {
+	rb.lock.Lock()
+	defer rb.lock.Unlock()
+	if rb.tokens < 1 {
+		return false
+	}
+	rb.tokens--
+	return true
+}
@@ -4,14 +4,23 @@ import (
 	"container/ring"
 	"errors"
 	"sync"
+	"time"
 )
 
 // roundRobinStrings returns a structure that on each invocation of its Next()
 // method, returns the next string value from the list of values when it was
 // initialized. On rollover, it returns the first value from the list.
 type roundRobinStrings struct {
-	r *ring.Ring
-	l sync.Mutex
+	r      *ring.Ring
+	l      sync.Mutex
+	values []string
+}
+
+// NewRoundRobinSelector returns a Selector that sends requests to each of the
+// specified range servers in turn, skipping servers that are not available.
+// This is the Selector used when the Configurator does not specify one.
+func NewRoundRobinSelector(servers []string) (Selector, error) {
+	return newRoundRobinStrings(servers)
 }
 
 func newRoundRobinStrings(someStrings []string) (*roundRobinStrings, error) {
@@ -29,17 +38,34 @@ func newRoundRobinStrings(someStrings []string) (*roundRobinStrings, error) {
 		r.Value = value
 	}
 
-	return &roundRobinStrings{r: r}, nil
+	return &roundRobinStrings{r: r, values: append([]string(nil), someStrings...)}, nil
 }
 
 // Len returns the number of strings in the roundRobinStrings structure.
 func (rr *roundRobinStrings) Len() int { return rr.r.Len() }
 
-// Next returns the next string in the roundRobinStrings structure.
-func (rr *roundRobinStrings) Next() string {
+// Next returns the next string in the roundRobinStrings structure for which
+// available returns true.  When available returns false for every string, it
+// returns the next string regardless.
+func (rr *roundRobinStrings) Next(available func(string) bool) string {
 	rr.l.Lock()
-	next := rr.r.Next()
-	rr.r = next
-	rr.l.Unlock()
-	return next.Value.(string)
+	defer rr.l.Unlock()
+	first := rr.r.Next()
+	rr.r = first
+	for next := first; ; {
+		if available(next.Value.(string)) {
+			rr.r = next
+			return next.Value.(string)
+		}
+		if next = next.Next(); next == first {
+			return first.Value.(string)
+		}
+	}
 }
+
+// Servers returns the strings in the roundRobinStrings structure.
+func (rr *roundRobinStrings) Servers() []string { return rr.values }
+
+// Observe ignores the outcome of requests, because round robin selection does
+// not depend on them.
+func (rr *roundRobinStrings) Observe(string, time.Duration, error) {}
@@ -0,0 +1,301 @@
+package gorange
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+)
+
+// DefaultLatencyDecay is used when no decay is provided to NewLatencySelector,
+// to control how quickly observed latencies are forgotten.
+const DefaultLatencyDecay = 10 * time.Second
+
+// DefaultLocalityCooldown is used when no cooldown is provided to
+// NewLocalitySelector, to control how long a failed range server is avoided.
+const DefaultLocalityCooldown = 30 * time.Second
+
+// Selector is the interface implemented by a structure that chooses which range
+// server receives each request.  Provide an instance as the Configurator's
+// Selector field to replace the default round robin selection.  Methods may be
+// invoked concurrently, so they must be safe for concurrent use.
+type Selector interface {
+	// Servers returns the addresses of every range server the Selector may
+	// return from Next.
+	Servers() []string
+
+	// Next returns the address of the range server that ought to receive the
+	// next request.  It ought to prefer range servers for which available
+	// returns true, which are those whose circuit breaker is closed, but when
+	// no range server is available, it must return one of them regardless.
+	Next(available func(server string) bool) string
+
+	// Observe is invoked after each request to a range server completes, with
+	// the elapsed time, and a non-nil error when the request failed because of
+	// the range server or the network, rather than because of the query.
+	Observe(server string, latency time.Duration, err error)
+}
+
+////////////////////////////////////////
+// weighted
+
+// weightedSelector distributes requests among range servers in proportion to
+// their weights, using the smooth weighted round robin algorithm, which
+// interleaves servers rather than sending bursts to the heaviest.
+type weightedSelector struct {
+	servers []string
+	weights []int
+	current []int
+	lock    sync.Mutex
+}
+
+// NewWeightedSelector returns a Selector that distributes requests among the
+// range servers in proportion to their weights, such that a range server with
+// weight 3 receives three times as many requests as one with weight 1.  Every
+// weight must be positive.
+//
+//	selector, err := gorange.NewWeightedSelector(map[string]int{
+//		"range1.dc1.example.com": 8,
+//		"range1.dc2.example.com": 1,
+//		"range2.dc2.example.com": 1,
+//	})
+func NewWeightedSelector(weights map[string]int) (Selector, error) {
+	if len(weights) == 0 {
+		return nil, errors.New("cannot create weighted selector without at least one range server")
+	}
+	ws := &weightedSelector{}
+	for server := range weights {
+		ws.servers = append(ws.servers, server)
+	}
+	sort.Strings(ws.servers)
+	for _, server := range ws.servers {
+		weight := weights[server]
+		if weight <= 0 {
+			return nil, fmt.Errorf("cannot create weighted selector with non-positive weight for %q: %d", server, weight)
+		}
+		ws.weights = append(ws.weights, weight)
+	}
+	ws.current = make([]int, len(ws.servers))
+	return ws, nil
+}
+
+func (ws *weightedSelector) Servers() []string { return ws.servers }
+
+func (ws *weightedSelector) Next(available func(string) bool) string {
+	ws.lock.Lock()
+	defer ws.lock.Unlock()
+	if s := ws.pick(available); s >= 0 {
+		return ws.servers[s]
+	}
+	return ws.servers[ws.pick(func(string) bool { return true })]
+}
+
+// pick returns the index of the next available server, or -1 when none are
+// available.
+func (ws *weightedSelector) pick(available func(string) bool) int {
+	best, total := -1, 0
+	for i, server := range ws.servers {
+		if !available(server) {
+			continue
+		}
+		ws.current[i] += ws.weights[i]
+		total += ws.weights[i]
+		if best < 0 || ws.current[i] > ws.current[best] {
+			best = i
+		}
+	}
+	if best >= 0 {
+		ws.current[best] -= total
+	}
+	return best
+}
+
+func (ws *weightedSelector) Observe(string, time.Duration, error) {}
+
+////////////////////////////////////////
+// latency
+
+// latencySelector picks two random available range servers and sends the
+// request to the one with the lower exponentially weighted moving average
+// latency.  The average of a server decays toward zero while it is not
+// receiving requests, so that slow servers are periodically retried.
+type latencySelector struct {
+	decay   time.Duration
+	servers []string
+	stats   map[string]*latencyStats
+	random  *rand.Rand
+	lock    sync.Mutex
+}
+
+type latencyStats struct {
+	ewma float64 // nanoseconds
+	when time.Time
+}
+
+// NewLatencySelector returns a Selector that, for each request, considers two
+// randomly chosen range servers, and selects the one with the lower recent
+// latency.  Failed requests count as DefaultQueryTimeout latency.  Observed
+// latencies are forgotten with the time constant specified by decay, or
+// DefaultLatencyDecay when decay is 0.
+func NewLatencySelector(servers []string, decay time.Duration) (Selector, error) {
+	if len(servers) == 0 {
+		return nil, errors.New("cannot create latency selector without at least one range server")
+	}
+	if decay < 0 {
+		return nil, fmt.Errorf("cannot create latency selector with negative decay: %s", decay)
+	}
+	if decay == 0 {
+		decay = DefaultLatencyDecay
+	}
+	ls := &latencySelector{
+		decay:   decay,
+		servers: append([]string(nil), servers...),
+		stats:   make(map[string]*latencyStats, len(servers)),
+		random:  rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+	for _, server := range servers {
+		ls.stats[server] = new(latencyStats)
+	}
+	return ls, nil
+}
+
+func (ls *latencySelector) Servers() []string { return ls.servers }
+
+// weight returns the multiplier for a value observed elapsed time ago.
+func (ls *latencySelector) weight(elapsed time.Duration) float64 {
+	return math.Exp(-float64(elapsed) / float64(ls.decay))
+}
+
+// cost returns the decayed latency of the server.
+func (ls *latencySelector) cost(server string, now time.Time) float64 {
+	st := ls.stats[server]
+	return st.ewma * ls.weight(now.Sub(st.when))
+}
+
+func (ls *latencySelector) Next(available func(string) bool) string {
+	ls.lock.Lock()
+	defer ls.lock.Unlock()
+
+	candidates := make([]string, 0, len(ls.servers))
+	for _, server := range ls.servers {
+		if available(server) {
+			candidates = append(candidates, server)
+		}
+	}
+	if len(candidates) == 0 {
+		candidates = ls.servers
+	}
+	if len(candidates) == 1 {
+		return candidates[0]
+	}
+
+	i := ls.random.Intn(len(candidates))
+	j := ls.random.Intn(len(candidates) - 1)
+	if j >= i {
+		j++ // ensure two distinct candidates
+	}
+	now := time.Now()
+	if ls.cost(candidates[j], now) < ls.cost(candidates[i], now) {
+		return candidates[j]
+	}
+	return candidates[i]
+}
+
+func (ls *latencySelector) Observe(server string, latency time.Duration, err error) {
+	if err != nil {
+		latency = DefaultQueryTimeout
+	}
+	ls.lock.Lock()
+	defer ls.lock.Unlock()
+	st, ok := ls.stats[server]
+	if !ok {
+		return
+	}
+	now := time.Now()
+	w := ls.weight(now.Sub(st.when))
+	st.ewma = st.ewma*w + float64(latency)*(1-w)
+	st.when = now
+}
+
+////////////////////////////////////////
+// locality
+
+// localitySelector sends requests to the range servers in the first tier that
+// has an available server, using each tier's Selector to choose among the range
+// servers within that tier.
+type localitySelector struct {
+	cooldown time.Duration
+	servers  []string
+	tiers    []Selector
+	failed   map[string]time.Time // when each server last failed
+	lock     sync.Mutex
+}
+
+// NewLocalitySelector returns a Selector that prefers the range servers in the
+// first tier, such as those in the local datacenter, sending requests to them
+// in round robin order.  It fails over to the next tier only when no range
+// server in the preceding tiers is available, either because its circuit
+// breaker is open, or because a request to it failed less than cooldown ago.
+// When cooldown is 0, DefaultLocalityCooldown is used.
+//
+//	selector, err := gorange.NewLocalitySelector([][]string{
+//		{"range1.dc1.example.com", "range2.dc1.example.com"},
+//		{"range1.dc2.example.com", "range1.dc3.example.com"},
+//	}, 0)
+func NewLocalitySelector(tiers [][]string, cooldown time.Duration) (Selector, error) {
+	if cooldown < 0 {
+		return nil, fmt.Errorf("cannot create locality selector with negative cooldown: %s", cooldown)
+	}
+	if cooldown == 0 {
+		cooldown = DefaultLocalityCooldown
+	}
+	ls := &localitySelector{cooldown: cooldown, failed: make(map[string]time.Time)}
+	for _, tier := range tiers {
+		rrs, err := newRoundRobinStrings(tier)
+		if err != nil {
+			return nil, errors.New("cannot create locality selector with an empty tier")
+		}
+		ls.tiers = append(ls.tiers, rrs)
+		ls.servers = append(ls.servers, tier...)
+	}
+	if len(ls.tiers) == 0 {
+		return nil, errors.New("cannot create locality selector without at least one tier")
+	}
+	return ls, nil
+}
+
+func (ls *localitySelector) Servers() []string { return ls.servers }
+
+func (ls *localitySelector) Next(available func(string) bool) string {
+	now := time.Now()
+	healthy := func(server string) bool {
+		if !available(server) {
+			return false
+		}
+		ls.lock.Lock()
+		failed, ok := ls.failed[server]
+		ls.lock.Unlock()
+		return !ok || now.Sub(failed) >= ls.cooldown
+	}
+
+	for _, tier := range ls.tiers {
+		if server := tier.Next(healthy); healthy(server) {
+			return server
+		}
+	}
+	// Nothing is healthy, so prefer the first tier.
+	return ls.tiers[0].Next(available)
+}
+
+func (ls *localitySelector) Observe(server string, _ time.Duration, err error) {
+	ls.lock.Lock()
+	defer ls.lock.Unlock()
+	if err != nil {
+		ls.failed[server] = time.Now()
+	} else {
+		delete(ls.failed, server)
+	}
+}
@@ -0,0 +1,159 @@
+package gorange
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultServerResolverPeriodicity is used when a ServerResolver is provided
+// but no ServerResolverPeriodicity, to control how often the set of range
+// servers is resolved.
+const DefaultServerResolverPeriodicity = 1 * time.Minute
+
+// serverSet is the Selector used to choose range servers, along with the URL
+// prefix of each range server it lists.  It is replaced as a whole when the
+// range servers change, so a query in flight continues to use the set it
+// started with.
+type serverSet struct {
+	selector  Selector
+	endpoints map[string]string // server address -> URL prefix
+}
+
+func newServerSet(selector Selector) (*serverSet, error) {
+	servers := selector.Servers()
+	if len(servers) == 0 {
+		return nil, fmt.Errorf("without at least one range server address")
+	}
+	endpoints := make(map[string]string, len(servers))
+	for _, server := range servers {
+		endpoint, err := serverEndpoint(server)
+		if err != nil {
+			return nil, fmt.Errorf("with invalid range server address: %s", err)
+		}
+		endpoints[server] = endpoint
+	}
+	return &serverSet{selector: selector, endpoints: endpoints}, nil
+}
+
+// endpoint returns the URL prefix for the range server address.
+func (ss *serverSet) endpoint(server string) (string, error) {
+	if endpoint, ok := ss.endpoints[server]; ok {
+		return endpoint, nil
+	}
+	// Selector returned a server it did not list.
+	return serverEndpoint(server)
+}
+
+// SetServers replaces the range servers to which queries are sent, without
+// disturbing queries already in flight, which complete using the previous range
+// servers.  The new range servers are selected in round robin order, replacing
+// any Selector provided to NewQuerier; use SetSelector to use a different
+// selection strategy.  It returns an error, and leaves the range servers
+// unchanged, when servers is empty or contains an invalid address.
+func (c *Client) SetServers(servers []string) error {
+	selector, err := newRoundRobinStrings(servers)
+	if err != nil {
+		return fmt.Errorf("cannot set servers without at least one range server address")
+	}
+	return c.SetSelector(selector)
+}
+
+// SetSelector replaces the Selector that chooses the range servers to which
+// queries are sent, without disturbing queries already in flight.  It returns
+// an error, and leaves the range servers unchanged, when the Selector lists no
+// range servers or lists an invalid address.
+func (c *Client) SetSelector(selector Selector) error {
+	set, err := newServerSet(selector)
+	if err != nil {
+		return fmt.Errorf("cannot set servers %s", err)
+	}
+	servers := selector.Servers()
+
+	c.serversLock.Lock()
+	c.servers = set
+	c.serversLock.Unlock()
+
+	if c.health != nil {
+		c.health.setServers(servers)
+	}
+	if c.versions != nil {
+		c.versions.setServers(servers)
+	}
+	return nil
+}
+
+// serverSet returns the current set of range servers.
+func (c *Client) serverSet() *serverSet {
+	c.serversLock.RLock()
+	defer c.serversLock.RUnlock()
+	return c.servers
+}
+
+// serverCount returns the number of current range servers.
+func (c *Client) serverCount() int {
+	return len(c.serverSet().selector.Servers())
+}
+
+// serverResolver periodically invokes a callback that returns a Selector for
+// the range servers, and updates the Client when they change.  When the
+// callback fails, the Client continues to use the last known range servers.
+type serverResolver struct {
+	halt chan struct{}
+	done sync.WaitGroup
+}
+
+// resolveFunc returns a Selector for the current range servers, along with a
+// key that is equal for results that would select range servers in the same
+// way, so the Client is only updated when the range servers change.
+type resolveFunc func() (string, Selector, error)
+
+func newServerResolver(c *Client, periodicity time.Duration, resolve resolveFunc, previous string) *serverResolver {
+	sr := &serverResolver{halt: make(chan struct{})}
+	sr.done.Add(1)
+	go func() {
+		defer sr.done.Done()
+		ticker := time.NewTicker(periodicity)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				key, selector, err := resolve()
+				if err != nil || key == previous {
+					continue // keep last known range servers
+				}
+				if c.SetSelector(selector) == nil {
+					previous = key
+				}
+			case <-sr.halt:
+				return
+			}
+		}
+	}()
+	return sr
+}
+
+func (sr *serverResolver) close() {
+	close(sr.halt)
+	sr.done.Wait()
+}
+
+// resolveServers adapts a ServerResolver callback to a resolveFunc that selects
+// the range servers in round robin order.
+func resolveServers(resolver func() ([]string, error)) resolveFunc {
+	return func() (string, Selector, error) {
+		servers, err := resolver()
+		if err != nil {
+			return "", nil, err
+		}
+		selector, err := newRoundRobinStrings(servers)
+		if err != nil {
+			return "", nil, fmt.Errorf("no range server addresses")
+		}
+		sorted := append([]string(nil), servers...)
+		sort.Strings(sorted)
+		return strings.Join(sorted, "\n"), selector, nil
+	}
+}
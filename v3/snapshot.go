@@ -0,0 +1,190 @@
+package gorange
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+
+	"github.com/karrick/goswarm"
+)
+
+// cacheSnapshotFormat is the format version written to cache snapshot files.
+// Snapshot files with a different format version are ignored at startup.
+const cacheSnapshotFormat = 1
+
+// cacheSnapshot is the contents of a cache snapshot file.
+type cacheSnapshot struct {
+	Format  int                  `json:"format"`
+	Version int64                `json:"version"` // last known `%version`
+	Written time.Time            `json:"written"`
+	Entries []cacheSnapshotEntry `json:"entries"`
+}
+
+// cacheSnapshotEntry is a single cached result.
+type cacheSnapshotEntry struct {
+	Expression  string        `json:"expression"`
+	Lines       []string      `json:"lines"`
+	Server      string        `json:"server,omitempty"`
+	Method      string        `json:"method,omitempty"`
+	Attempts    int           `json:"attempts,omitempty"`
+	Latency     time.Duration `json:"latency,omitempty"`
+	Version     int64         `json:"resultVersion,omitempty"`
+	Received    time.Time     `json:"received"`
+	Stale       time.Time     `json:"stale"`
+	Expiry      time.Time     `json:"expiry"`
+	LastRequest time.Time     `json:"lastRequest"`
+}
+
+// saveSnapshot writes the successful results in the cache to the cache file,
+// atomically replacing the previous snapshot, so a reader never observes a
+// partially written file.
+func (cc *CachingClient) saveSnapshot() error {
+	snapshot := cacheSnapshot{
+		Format:  cacheSnapshotFormat,
+		Version: atomic.LoadInt64(&cc.version),
+		Written: time.Now(),
+	}
+
+	cc.cache.Range(func(key string, tv *goswarm.TimedValue) {
+		result, ok := tv.Value.(*Result)
+		if tv.Err != nil || !ok {
+			return // errors are not worth persisting
+		}
+		entry := cacheSnapshotEntry{
+			Expression: key,
+			Lines:      result.Lines,
+			Server:     result.Server,
+			Method:     result.Method,
+			Attempts:   result.Attempts,
+			Latency:    result.Latency,
+			Version:    result.Version,
+			Received:   result.received,
+			Stale:      tv.Stale,
+			Expiry:     tv.Expiry,
+		}
+		if lrt, ok := cc.lastRequestTimes.Load(key); ok {
+			entry.LastRequest = lrt.(time.Time)
+		}
+		snapshot.Entries = append(snapshot.Entries, entry)
+	})
+
+	buf, err := json.Marshal(snapshot)
+	if err != nil {
+		return fmt.Errorf("cannot encode cache snapshot: %s", err)
+	}
+	if err = writeFileAtomic(cc.config.cacheFile, buf); err != nil {
+		return fmt.Errorf("cannot write cache snapshot: %s", err)
+	}
+	return nil
+}
+
+// loadSnapshot populates the cache from the cache file, when one exists and can
+// be decoded.  The restored results are stale, so the first query for each
+// triggers an asynchronous refresh, while the restored result continues to be
+// served until a refresh succeeds.  Restored results do not expire sooner than
+// TTE after they are loaded, regardless of the expiry recorded in the snapshot.
+func (cc *CachingClient) loadSnapshot() error {
+	buf, err := ioutil.ReadFile(cc.config.cacheFile)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil // nothing saved yet
+		}
+		return fmt.Errorf("cannot read cache snapshot: %s", err)
+	}
+
+	var snapshot cacheSnapshot
+	if err = json.Unmarshal(buf, &snapshot); err != nil {
+		return nil // truncated or corrupt, so start with an empty cache
+	}
+	if snapshot.Format != cacheSnapshotFormat {
+		return nil // written by an incompatible version of this library
+	}
+
+	now := time.Now()
+	restored := make(map[string]goswarm.TimedValue, len(snapshot.Entries))
+	for _, entry := range snapshot.Entries {
+		expiry := entry.Expiry
+		if !expiry.IsZero() {
+			if cc.config.expiry == 0 {
+				expiry = time.Time{}
+			} else if later := now.Add(cc.config.expiry); expiry.Before(later) {
+				expiry = later
+			}
+		}
+		restored[entry.Expression] = goswarm.TimedValue{
+			Value: &Result{
+				Expression: entry.Expression,
+				Lines:      entry.Lines,
+				Server:     entry.Server,
+				Method:     entry.Method,
+				Attempts:   entry.Attempts,
+				Latency:    entry.Latency,
+				Version:    entry.Version,
+				received:   entry.Received,
+			},
+			Stale:  now,
+			Expiry: expiry,
+		}
+		lastRequest := entry.LastRequest
+		if lastRequest.IsZero() {
+			lastRequest = now
+		}
+		cc.lastRequestTimes.Store(entry.Expression, lastRequest)
+	}
+
+	atomic.StoreInt64(&cc.version, snapshot.Version)
+
+	// The cache's lookup function returns the restored value rather than
+	// querying the range servers while the restored map holds the key.
+	cc.restoredLock.Lock()
+	cc.restored = restored
+	cc.restoredLock.Unlock()
+	for key := range restored {
+		cc.cache.Update(key)
+	}
+	cc.restoredLock.Lock()
+	cc.restored = nil
+	cc.restoredLock.Unlock()
+	return nil
+}
+
+// takeRestored returns the value restored from a cache snapshot for the
+// expression, if any, and removes it so it is used only once.
+func (cc *CachingClient) takeRestored(expression string) (goswarm.TimedValue, bool) {
+	cc.restoredLock.Lock()
+	defer cc.restoredLock.Unlock()
+	tv, ok := cc.restored[expression]
+	if ok {
+		delete(cc.restored, expression)
+	}
+	return tv, ok
+}
+
+// writeFileAtomic writes data to a temporary file in the same directory as
+// path, then renames it to path.
+func writeFileAtomic(path string, data []byte) error {
+	fh, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	tempName := fh.Name()
+
+	_, err = fh.Write(data)
+	if err == nil {
+		err = fh.Sync()
+	}
+	if cerr := fh.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tempName, path)
+	}
+	if err != nil {
+		_ = os.Remove(tempName)
+	}
+	return err
+}
@@ -0,0 +1,100 @@
+package gorange
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SRVResolver is the interface implemented by a structure that resolves DNS SRV
+// records, such as *net.Resolver.  Provide an instance as the Configurator's
+// SRVResolver field to control how SRVName is resolved, for instance to
+// resolve it without DNS in tests.
+type SRVResolver interface {
+	// LookupSRV returns the SRV records for the service, protocol, and name,
+	// with the same semantics as the method of *net.Resolver.  It is invoked
+	// with empty service and protocol, and the complete SRV name, such as
+	// `_range._tcp.example.com`.
+	LookupSRV(ctx context.Context, service, proto, name string) (string, []*net.SRV, error)
+}
+
+// resolveSRV returns a resolveFunc that looks up the SRV records for name, and
+// builds a Selector for the range servers they list.
+func resolveSRV(resolver SRVResolver, name string) resolveFunc {
+	return func() (string, Selector, error) {
+		ctx, cancel := context.WithTimeout(context.Background(), DefaultQueryTimeout)
+		defer cancel()
+		_, records, err := resolver.LookupSRV(ctx, "", "", name)
+		if err != nil {
+			return "", nil, err
+		}
+		key, selector := srvSelector(records)
+		if selector == nil {
+			return "", nil, fmt.Errorf("no SRV records for %q", name)
+		}
+		return key, selector, nil
+	}
+}
+
+// srvSelector returns a Selector that sends requests to the range servers with
+// the lowest SRV priority, in proportion to their SRV weights, failing over to
+// range servers with the next lowest priority only when none of them is
+// available, in the same way as NewLocalitySelector.  Because the smooth
+// weighted round robin algorithm requires positive weights, a weight of 0 is
+// treated as 1, so such range servers receive a small share of requests.  It
+// also returns a key that describes the records, and returns a nil Selector when
+// there are no usable records.
+func srvSelector(records []*net.SRV) (string, Selector) {
+	sorted := make([]*net.SRV, 0, len(records))
+	for _, record := range records {
+		if record.Target != "." { // "." means the service is not available
+			sorted = append(sorted, record)
+		}
+	}
+	if len(sorted) == 0 {
+		return "", nil
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].Priority != sorted[j].Priority {
+			return sorted[i].Priority < sorted[j].Priority
+		}
+		if sorted[i].Target != sorted[j].Target {
+			return sorted[i].Target < sorted[j].Target
+		}
+		return sorted[i].Port < sorted[j].Port
+	})
+
+	ls := &localitySelector{cooldown: DefaultLocalityCooldown, failed: make(map[string]time.Time)}
+	seen := make(map[string]struct{}, len(sorted))
+	var key strings.Builder
+
+	for i := 0; i < len(sorted); {
+		weights := make(map[string]int)
+		j := i
+		for ; j < len(sorted) && sorted[j].Priority == sorted[i].Priority; j++ {
+			server := net.JoinHostPort(strings.TrimSuffix(sorted[j].Target, "."), strconv.Itoa(int(sorted[j].Port)))
+			if _, ok := seen[server]; ok && weights[server] == 0 {
+				continue // already listed with a lower priority
+			}
+			seen[server] = struct{}{}
+			weight := int(sorted[j].Weight)
+			if weight == 0 {
+				weight = 1
+			}
+			weights[server] += weight
+			fmt.Fprintf(&key, "%d %d %s\n", sorted[j].Priority, weight, server)
+		}
+		i = j
+		if len(weights) == 0 {
+			continue
+		}
+		tier, _ := NewWeightedSelector(weights) // weights are never empty or non-positive
+		ls.tiers = append(ls.tiers, tier)
+		ls.servers = append(ls.servers, tier.Servers()...)
+	}
+	return key.String(), ls
+}
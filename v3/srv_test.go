@@ -0,0 +1,124 @@
+package gorange
+
+import (
+	"context"
+	"errors"
+	"net"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeSRVResolver returns the configured records or error, without using DNS.
+type fakeSRVResolver struct {
+	lock    sync.Mutex
+	records []*net.SRV
+	err     error
+	lookups int
+}
+
+func (f *fakeSRVResolver) LookupSRV(_ context.Context, _, _, name string) (string, []*net.SRV, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.lookups++
+	return name, f.records, f.err
+}
+
+func (f *fakeSRVResolver) set(records []*net.SRV, err error) {
+	f.lock.Lock()
+	f.records, f.err = records, err
+	f.lock.Unlock()
+}
+
+func (f *fakeSRVResolver) count() int {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return f.lookups
+}
+
+func TestSRVSelectorPriorityAndWeight(t *testing.T) {
+	_, selector := srvSelector([]*net.SRV{
+		{Target: "backup.example.com.", Port: 8080, Priority: 20, Weight: 1},
+		{Target: "heavy.example.com.", Port: 80, Priority: 10, Weight: 3},
+		{Target: "light.example.com.", Port: 80, Priority: 10, Weight: 1},
+		{Target: ".", Port: 80, Priority: 5, Weight: 1}, // service not available
+	})
+	if selector == nil {
+		t.Fatal("srvSelector returned nil Selector")
+	}
+
+	want := []string{"heavy.example.com:80", "light.example.com:80", "backup.example.com:8080"}
+	if got := selector.Servers(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("Servers() = %q; want %q", got, want)
+	}
+
+	all := func(string) bool { return true }
+	counts := make(map[string]int)
+	for i := 0; i < 400; i++ {
+		counts[selector.Next(all)]++
+	}
+	if counts["heavy.example.com:80"] != 300 || counts["light.example.com:80"] != 100 {
+		t.Errorf("lowest priority tier not distributed by weight: %v", counts)
+	}
+	if counts["backup.example.com:8080"] != 0 {
+		t.Errorf("higher priority tier used while lower tier available: %v", counts)
+	}
+
+	// Fail over to the next tier when the lowest priority tier is unavailable.
+	onlyBackup := func(server string) bool { return server == "backup.example.com:8080" }
+	if got := selector.Next(onlyBackup); got != "backup.example.com:8080" {
+		t.Errorf("Next() = %q; want failover to backup.example.com:8080", got)
+	}
+}
+
+func TestSRVSelectorNoRecords(t *testing.T) {
+	if _, selector := srvSelector([]*net.SRV{{Target: "."}}); selector != nil {
+		t.Errorf("srvSelector returned Selector for unusable records: %q", selector.Servers())
+	}
+}
+
+func TestSRVKeepsLastKnownServers(t *testing.T) {
+	resolver := &fakeSRVResolver{records: []*net.SRV{
+		{Target: "range1.example.com.", Port: 80, Priority: 10, Weight: 1},
+	}}
+	querier, err := NewQuerier(&Configurator{
+		SRVName:                   "_range._tcp.example.com",
+		SRVResolver:               resolver,
+		ServerResolverPeriodicity: 5 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = querier.Close() }()
+	client := querier.(*Client)
+
+	servers := func() []string { return client.serverSet().selector.Servers() }
+	waitForLookups := func() {
+		for n := resolver.count() + 2; resolver.count() < n; {
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	if got, want := servers(), []string{"range1.example.com:80"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("initial servers = %q; want %q", got, want)
+	}
+
+	resolver.set(nil, errors.New("SERVFAIL"))
+	waitForLookups()
+	if got, want := servers(), []string{"range1.example.com:80"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("servers after failed resolution = %q; want %q", got, want)
+	}
+
+	resolver.set(nil, nil)
+	waitForLookups()
+	if got, want := servers(), []string{"range1.example.com:80"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("servers after empty resolution = %q; want %q", got, want)
+	}
+
+	resolver.set([]*net.SRV{{Target: "range2.example.com.", Port: 8080, Priority: 10, Weight: 1}}, nil)
+	waitForLookups()
+	if got, want := servers(), []string{"range2.example.com:8080"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("servers after records changed = %q; want %q", got, want)
+	}
+}
@@ -0,0 +1,80 @@
+package gorange
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"strings"
+)
+
+// serverEndpoint converts a range server address from the Configurator into
+// the URL prefix to which the range API paths are appended.  A bare network
+// address is presumed to use HTTP, while a full URL may specify the scheme,
+// port, and a path prefix, or the path of a Unix domain socket.
+func serverEndpoint(server string) (string, error) {
+	if server == "" {
+		return "", errors.New("empty address")
+	}
+	if !strings.Contains(server, "://") {
+		return "http://" + server, nil
+	}
+	u, err := url.Parse(server)
+	if err != nil {
+		return "", err
+	}
+	if u.RawQuery != "" || u.Fragment != "" {
+		return "", fmt.Errorf("cannot include query or fragment: %q", server)
+	}
+	switch u.Scheme {
+	case "http", "https":
+	case "unix":
+		if u.Host != "" || !strings.HasPrefix(u.Path, "/") {
+			return "", fmt.Errorf("socket path must be absolute, as in unix:///path/to/sock: %q", server)
+		}
+		return unixEndpoint(u.Path), nil
+	default:
+		return "", fmt.Errorf("unsupported scheme: %q", u.Scheme)
+	}
+	if u.Host == "" {
+		return "", fmt.Errorf("missing host: %q", server)
+	}
+	return u.Scheme + "://" + u.Host + strings.TrimRight(u.EscapedPath(), "/"), nil
+}
+
+// newTLSConfig returns a TLS configuration built from the TLS options of the
+// Configurator, or nil when none of those options are provided.
+func newTLSConfig(config *Configurator) (*tls.Config, error) {
+	if config.TLSCAFile == "" && config.TLSCertFile == "" && config.TLSKeyFile == "" && config.TLSServerName == "" {
+		return nil, nil
+	}
+
+	tlsConfig := &tls.Config{ServerName: config.TLSServerName}
+
+	if config.TLSCAFile != "" {
+		buf, err := ioutil.ReadFile(config.TLSCAFile)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(buf) {
+			return nil, fmt.Errorf("cannot find any PEM encoded certificates: %q", config.TLSCAFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	if config.TLSCertFile != "" || config.TLSKeyFile != "" {
+		if config.TLSCertFile == "" || config.TLSKeyFile == "" {
+			return nil, errors.New("TLSCertFile and TLSKeyFile must be provided together")
+		}
+		cert, err := tls.LoadX509KeyPair(config.TLSCertFile, config.TLSKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	return tlsConfig, nil
+}
@@ -0,0 +1,35 @@
+package gorange
+
+import (
+	"context"
+	"encoding/hex"
+	"net"
+	"strings"
+)
+
+// unixHostSuffix ends the host of each URL prefix built from a `unix://` range
+// server address, whose remaining labels are the hex encoded socket path.  The
+// `.invalid` top level domain is reserved, so the host cannot conflict with a
+// real range server.
+const unixHostSuffix = ".unix.invalid"
+
+// unixEndpoint returns the URL prefix for a range server listening on the Unix
+// domain socket at path.  HTTP requires a host in each URL, so the socket path
+// is encoded in a host recognized by unixDialContext.
+func unixEndpoint(path string) string {
+	return "http://" + hex.EncodeToString([]byte(path)) + unixHostSuffix
+}
+
+// unixDialContext returns a function that dials the Unix domain socket encoded
+// in each host built by unixEndpoint, and uses dialer to dial every other
+// address.
+func unixDialContext(dialer *net.Dialer) func(context.Context, string, string) (net.Conn, error) {
+	return func(ctx context.Context, network, address string) (net.Conn, error) {
+		if host, _, err := net.SplitHostPort(address); err == nil && strings.HasSuffix(host, unixHostSuffix) {
+			if path, err := hex.DecodeString(strings.TrimSuffix(host, unixHostSuffix)); err == nil {
+				return dialer.DialContext(ctx, "unix", string(path))
+			}
+		}
+		return dialer.DialContext(ctx, network, address)
+	}
+}
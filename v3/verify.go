@@ -0,0 +1,227 @@
+package gorange
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Verification is the result of sending the same query to several range
+// servers, and comparing their responses.
+type Verification struct {
+	// Expression is the query expression.
+	Expression string
+
+	// Lines is the majority result, namely the set of strings returned by the
+	// most range servers.  When several results are returned by equally many
+	// range servers, it is the result of the earliest configured range server
+	// among those queried.
+	Lines []string
+
+	// Version is the most common `%version` among the range servers that
+	// returned the majority result, or 0 when unknown.
+	Version int64
+
+	// Servers is the number of range servers queried.
+	Servers int
+
+	// Agreed is the number of range servers that returned the majority result
+	// with the majority version.
+	Agreed int
+
+	// Divergences describes each range server that failed, or whose result or
+	// version differs from the majority, in the order the range servers are
+	// configured.  It is empty when every range server agrees.
+	Divergences []Divergence
+}
+
+// Divergence describes how a single range server's response differs from the
+// majority.
+type Divergence struct {
+	// Server is the range server address.
+	Server string
+
+	// Err is the error returned by the range server, in which case the other
+	// fields are empty.
+	Err error
+
+	// Version is the `%version` reported by the range server, or 0 when
+	// unknown.
+	Version int64
+
+	// Added lists the strings the range server returned that are not in the
+	// majority result.
+	Added []string
+
+	// Removed lists the strings in the majority result that the range server
+	// did not return.
+	Removed []string
+}
+
+// serverAnswer is a single range server's response to a verified query.
+type serverAnswer struct {
+	server  string
+	lines   []string
+	key     string // canonical form of lines, for comparison
+	version int64
+	err     error
+}
+
+// Verify sends the expression to several range servers concurrently, by
+// default all of them, and compares their results and `%version` values.  It
+// returns the majority result along with a report of each range server that
+// diverges from it, which is useful for detecting range servers with stuck or
+// partial data reloads.  Requests are sent directly to each range server,
+// without retries, and regardless of circuit breakers.  It returns an error
+// only when every range server fails.
+//
+//	verification, err := querier.(gorange.Verifier).Verify("%someQuery")
+//	if err != nil {
+//		fmt.Fprintf(os.Stderr, "ERROR: %s", err)
+//		os.Exit(1)
+//	}
+//	for _, d := range verification.Divergences {
+//		fmt.Printf("%s diverges: version %d; added %v; removed %v; error %v\n", d.Server, d.Version, d.Added, d.Removed, d.Err)
+//	}
+func (c *Client) Verify(expression string) (*Verification, error) {
+	return c.VerifyContext(context.Background(), expression)
+}
+
+// VerifyContext is like Verify, but aborts the queries and returns the
+// context's error when the provided context is done before they complete.
+func (c *Client) VerifyContext(ctx context.Context, expression string) (*Verification, error) {
+	servers := c.verifyServers()
+	if len(servers) == 0 {
+		return nil, errNoServers
+	}
+	answers := make([]serverAnswer, len(servers))
+
+	var wg sync.WaitGroup
+	wg.Add(len(servers))
+	for i, server := range servers {
+		go func(i int, server string) {
+			defer wg.Done()
+			answers[i] = c.askServer(ctx, server, expression)
+		}(i, server)
+	}
+	wg.Wait()
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	// Group the successful answers by their result, then find the result
+	// returned by the most range servers.  When tied, prefer the result of the
+	// earliest configured range server among those queried, because answers
+	// are in configured order.
+	counts := make(map[string]int)
+	for _, a := range answers {
+		if a.err == nil {
+			counts[a.key]++
+		}
+	}
+	var majority *serverAnswer
+	for i := range answers {
+		a := &answers[i]
+		if a.err == nil && (majority == nil || counts[a.key] > counts[majority.key]) {
+			majority = a
+		}
+	}
+	if majority == nil {
+		return nil, answers[0].err
+	}
+
+	// Among the range servers returning the majority result, find the most
+	// common version, preferring the higher version when tied.
+	versions := make(map[int64]int)
+	var version int64
+	for _, a := range answers {
+		if a.err == nil && a.key == majority.key {
+			versions[a.version]++
+			if n := versions[a.version]; n > versions[version] || (n == versions[version] && a.version > version) {
+				version = a.version
+			}
+		}
+	}
+
+	v := &Verification{
+		Expression: expression,
+		Lines:      majority.lines,
+		Version:    version,
+		Servers:    len(servers),
+	}
+	for _, a := range answers {
+		switch {
+		case a.err != nil:
+			v.Divergences = append(v.Divergences, Divergence{Server: a.server, Err: a.err})
+		case a.key != majority.key:
+			added, removed := diffLines(majority.lines, a.lines)
+			v.Divergences = append(v.Divergences, Divergence{
+				Server:  a.server,
+				Version: a.version,
+				Added:   added,
+				Removed: removed,
+			})
+		case a.version != version:
+			v.Divergences = append(v.Divergences, Divergence{Server: a.server, Version: a.version})
+		default:
+			v.Agreed++
+		}
+	}
+	return v, nil
+}
+
+// verifyServers returns the range servers to which Verify sends queries, which
+// is every range server, or a random selection of them when VerifyServers
+// limits their number.
+func (c *Client) verifyServers() []string {
+	servers := c.serverSet().selector.Servers()
+	if c.verifyCount == 0 || c.verifyCount >= len(servers) {
+		return servers
+	}
+	indexes := rand.Perm(len(servers))[:c.verifyCount]
+	sort.Ints(indexes) // report divergences in the order servers are configured
+	selected := make([]string, 0, c.verifyCount)
+	for _, i := range indexes {
+		selected = append(selected, servers[i])
+	}
+	return selected
+}
+
+// askServer sends the expression and the `%version` query to a single range
+// server.
+func (c *Client) askServer(ctx context.Context, server, expression string) serverAnswer {
+	a := serverAnswer{server: server}
+
+	endpoint, err := c.endpoint(server)
+	if err != nil {
+		a.err = err
+		return a
+	}
+	resp, err := c.getFromServer(ctx, endpoint, listPath, expression)
+	if err != nil {
+		a.err = err
+		return a
+	}
+	a.err = c.scanLines(resp, func(line string) error {
+		a.lines = append(a.lines, line)
+		return nil
+	})
+	if a.err != nil {
+		return a
+	}
+
+	sorted := append([]string(nil), a.lines...)
+	sort.Strings(sorted)
+	a.key = strings.Join(sorted, "\n")
+
+	// A range server that does not support `%version` still participates.
+	a.version, _ = c.serverVersionContext(ctx, server)
+	return a
+}
+
+// errNoServers is returned by Verify when there are no range servers.
+var errNoServers = errors.New("cannot verify query without range servers")
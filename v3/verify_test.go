@@ -0,0 +1,58 @@
+package gorange
+
+import (
+	"fmt"
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+// newVerifyServer returns a range server that responds to every query with
+// lines, and to `%version` with version.
+func newVerifyServer(version int, lines ...string) *testRangeServer {
+	return newTestRangeServer(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.RawQuery == "%25version" {
+			fmt.Fprintln(w, version)
+			return
+		}
+		for _, line := range lines {
+			fmt.Fprintln(w, line)
+		}
+	})
+}
+
+func TestVerifyTiePrefersEarliestServer(t *testing.T) {
+	servers := []*testRangeServer{
+		newVerifyServer(1, "web1"),
+		newVerifyServer(1, "web2"),
+		newVerifyServer(1, "web2"),
+		newVerifyServer(1, "web1"),
+	}
+	var addresses []string
+	for _, server := range servers {
+		defer server.Close()
+		addresses = append(addresses, server.URL)
+	}
+
+	querier, err := NewQuerier(&Configurator{Servers: addresses})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = querier.Close() }()
+
+	v, err := querier.(*Client).Verify("web")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"web1"}; !reflect.DeepEqual(v.Lines, want) {
+		t.Errorf("Lines = %q; want %q", v.Lines, want)
+	}
+	if v.Version != 1 || v.Agreed != 2 || len(v.Divergences) != 2 {
+		t.Errorf("Version = %d, Agreed = %d, Divergences = %v; want 1, 2, and 2 divergences", v.Version, v.Agreed, v.Divergences)
+	}
+	for i, d := range v.Divergences {
+		if want := addresses[i+1]; d.Server != want {
+			t.Errorf("Divergences[%d].Server = %q; want %q", i, d.Server, want)
+		}
+	}
+}
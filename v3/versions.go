@@ -0,0 +1,205 @@
+package gorange
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultMonotonicReadsPeriodicity is used when MonotonicReads is enabled but
+// no MonotonicReadsPeriodicity is provided, to control how often the `%version`
+// of each range server is checked.
+const DefaultMonotonicReadsPeriodicity = 15 * time.Second
+
+// versionTracker periodically queries the `%version` of each range server, and
+// records the highest version observed from any of them, so queries can avoid
+// range servers whose data is older than data the client has already seen.
+type versionTracker struct {
+	lock     sync.Mutex
+	servers  []string
+	versions map[string]int64 // only servers whose version is known
+	highest  int64
+
+	halt chan struct{}
+	done sync.WaitGroup
+}
+
+func newVersionTracker(servers []string, periodicity time.Duration, check func(string) (int64, error)) *versionTracker {
+	vt := &versionTracker{
+		servers:  append([]string(nil), servers...),
+		versions: make(map[string]int64, len(servers)),
+		halt:     make(chan struct{}),
+	}
+	vt.done.Add(1)
+	go vt.run(periodicity, check)
+	return vt
+}
+
+// run checks the version of every server immediately, then once per period,
+// until the tracker is closed.
+func (vt *versionTracker) run(periodicity time.Duration, check func(string) (int64, error)) {
+	defer vt.done.Done()
+
+	ticker := time.NewTicker(periodicity)
+	defer ticker.Stop()
+
+	for {
+		vt.lock.Lock()
+		servers := vt.servers
+		vt.lock.Unlock()
+
+		var wg sync.WaitGroup
+		wg.Add(len(servers))
+		for _, server := range servers {
+			go func(server string) {
+				defer wg.Done()
+				if version, err := check(server); err == nil {
+					vt.observe(server, version)
+				}
+			}(server)
+		}
+		wg.Wait()
+
+		select {
+		case <-ticker.C:
+		case <-vt.halt:
+			return
+		}
+	}
+}
+
+// setServers changes the servers whose versions are checked, forgetting the
+// versions of servers no longer listed.  The highest version observed is kept,
+// so replacement servers with older data are still avoided.
+func (vt *versionTracker) setServers(servers []string) {
+	vt.lock.Lock()
+	defer vt.lock.Unlock()
+	listed := make(map[string]int64, len(servers))
+	for _, server := range servers {
+		if version, ok := vt.versions[server]; ok {
+			listed[server] = version
+		}
+	}
+	vt.servers = append([]string(nil), servers...)
+	vt.versions = listed
+}
+
+// observe records the version of the server, and returns the highest version
+// observed from any server.
+func (vt *versionTracker) observe(server string, version int64) int64 {
+	vt.lock.Lock()
+	defer vt.lock.Unlock()
+	vt.versions[server] = version
+	if version > vt.highest {
+		vt.highest = version
+	}
+	return vt.highest
+}
+
+// current returns true unless the server's version is known to be older than
+// the highest version observed.
+func (vt *versionTracker) current(server string) bool {
+	vt.lock.Lock()
+	defer vt.lock.Unlock()
+	version, ok := vt.versions[server]
+	return !ok || version >= vt.highest
+}
+
+// version returns the most recently observed version of the server, or 0 when
+// it is unknown.
+func (vt *versionTracker) version(server string) int64 {
+	vt.lock.Lock()
+	defer vt.lock.Unlock()
+	return vt.versions[server]
+}
+
+func (vt *versionTracker) snapshot() map[string]int64 {
+	vt.lock.Lock()
+	defer vt.lock.Unlock()
+	versions := make(map[string]int64, len(vt.versions))
+	for server, version := range vt.versions {
+		versions[server] = version
+	}
+	return versions
+}
+
+// close stops checking versions, and waits for any in-flight checks to
+// complete.
+func (vt *versionTracker) close() {
+	close(vt.halt)
+	vt.done.Wait()
+}
+
+// currentServer checks the `%version` of the selected range server before it is
+// sent a query, and selects another range server while the selected one's data
+// is older than data already observed.  Because the version of a range server
+// only increases, the query's response is never older than data already
+// observed.  It returns the selected range server along with its version, or 0
+// when its version cannot be checked, in which case the query is sent to it
+// regardless, so that its failure is handled like any other.  It returns
+// ErrStaleServers when no range server is current.
+func (c *Client) currentServer(ctx context.Context, set *serverSet, server string) (string, int64, error) {
+	var highest int64
+	for tries := len(set.selector.Servers()); tries > 0; tries-- {
+		version, err := c.serverVersionContext(ctx, server)
+		if err != nil {
+			if cerr := ctx.Err(); cerr != nil {
+				return "", 0, cerr
+			}
+			return server, 0, nil
+		}
+		if highest = c.versions.observe(server, version); version >= highest {
+			return server, version, nil
+		}
+		server = set.selector.Next(c.available)
+	}
+	return "", 0, ErrStaleServers{Version: highest}
+}
+
+// serverVersion sends the `%version` query to the specified range server, and
+// returns the version it reports.
+func (c *Client) serverVersion(server string) (int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), DefaultQueryTimeout)
+	defer cancel()
+	return c.serverVersionContext(ctx, server)
+}
+
+func (c *Client) serverVersionContext(ctx context.Context, server string) (int64, error) {
+	endpoint, err := c.endpoint(server)
+	if err != nil {
+		return 0, err
+	}
+	resp, err := c.getFromServer(ctx, endpoint, listPath, "%version")
+	if err != nil {
+		return 0, err
+	}
+
+	buf, err := ioutil.ReadAll(resp) // always read entire body
+	cerr := resp.Close()             // always close the stream
+	if err != nil {
+		return 0, ErrParseException{Err: err}
+	}
+	if cerr != nil {
+		return 0, ErrParseException{Err: cerr}
+	}
+
+	version, err := strconv.ParseInt(strings.TrimSpace(string(buf)), 10, 64)
+	if err != nil {
+		return 0, ErrParseException{Err: fmt.Errorf("%%version: %s", err)}
+	}
+	return version, nil
+}
+
+// ServerVersions returns the most recently observed `%version` of each range
+// server whose version is known, or nil when monotonic reads are not enabled by
+// the MonotonicReads configuration option.
+func (c *Client) ServerVersions() map[string]int64 {
+	if c.versions == nil {
+		return nil
+	}
+	return c.versions.snapshot()
+}
@@ -0,0 +1,167 @@
+package gorange
+
+import "sync"
+
+// watchBufferSize is the number of changes buffered for each watcher.  Changes
+// are dropped when a watcher falls further behind.
+const watchBufferSize = 16
+
+// Change describes how the result of a watched expression changed when the
+// CachingClient refreshed it.
+type Change struct {
+	// Expression is the watched expression.
+	Expression string
+
+	// Added lists the strings in the new result that were not in the previous
+	// result.
+	Added []string
+
+	// Removed lists the strings in the previous result that are not in the new
+	// result.
+	Removed []string
+
+	// Lines is the complete new result, allowing a watcher that missed changes
+	// to resynchronize.
+	Lines []string
+
+	// Version is the `%version` of the range data when the new result was
+	// obtained, or 0 when the version is unknown.
+	Version int64
+}
+
+// watchers tracks the channels on which changes to each expression are sent.
+type watchers struct {
+	lock     sync.Mutex
+	channels map[string]map[chan Change]struct{}
+	closed   bool
+}
+
+// Watch returns a channel on which a Change is sent each time a refresh of the
+// expression produces a different set of strings than the previous result,
+// along with a function that stops the watch and closes the channel.  Only
+// changes to the set of strings are reported, not changes to their order.
+//
+// Refreshes are triggered by `%version` changes when CheckVersionPeriodicity is
+// provided, and otherwise every TTL.  Watch does not query the expression, so
+// query it to obtain its current result and ensure it is cached.  A watched
+// expression is refreshed even when it has not recently been queried, and is
+// never evicted to keep the cache within its configured limits.  Up to 16
+// changes are buffered for a slow receiver, after which changes are dropped,
+// but each Change includes the complete new result.  The channel is closed
+// when the CachingClient is closed.
+//
+//	cc := querier.(*gorange.CachingClient)
+//	lines, err := cc.Query("%cluster-web")
+//	if err != nil {
+//		fmt.Fprintf(os.Stderr, "ERROR: %s", err)
+//		os.Exit(1)
+//	}
+//	changes, cancel := cc.Watch("%cluster-web")
+//	defer cancel()
+//	for change := range changes {
+//		fmt.Printf("added: %v; removed: %v\n", change.Added, change.Removed)
+//	}
+func (cc *CachingClient) Watch(expression string) (<-chan Change, func()) {
+	ch := make(chan Change, watchBufferSize)
+
+	w := &cc.watchers
+	w.lock.Lock()
+	if w.closed {
+		w.lock.Unlock()
+		close(ch)
+		return ch, func() {}
+	}
+	if w.channels == nil {
+		w.channels = make(map[string]map[chan Change]struct{})
+	}
+	if w.channels[expression] == nil {
+		w.channels[expression] = make(map[chan Change]struct{})
+	}
+	w.channels[expression][ch] = struct{}{}
+	w.lock.Unlock()
+
+	cancel := func() {
+		w.lock.Lock()
+		defer w.lock.Unlock()
+		if _, ok := w.channels[expression][ch]; !ok {
+			return // already canceled, or closed by CachingClient.Close
+		}
+		delete(w.channels[expression], ch)
+		if len(w.channels[expression]) == 0 {
+			delete(w.channels, expression)
+		}
+		close(ch)
+	}
+	return ch, cancel
+}
+
+// watched returns true when the expression has at least one watcher.
+func (w *watchers) watched(expression string) bool {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	return len(w.channels[expression]) > 0
+}
+
+// notify sends a Change to each watcher of the expression, when the new result
+// contains a different set of strings than the previous result.
+func (w *watchers) notify(expression string, previous, result *Result) {
+	added, removed := diffLines(previous.Lines, result.Lines)
+	if len(added) == 0 && len(removed) == 0 {
+		return
+	}
+
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	for ch := range w.channels[expression] {
+		change := Change{
+			Expression: expression,
+			Added:      append([]string(nil), added...),
+			Removed:    append([]string(nil), removed...),
+			Lines:      append([]string(nil), result.Lines...),
+			Version:    result.Version,
+		}
+		select {
+		case ch <- change:
+		default: // receiver is too far behind
+		}
+	}
+}
+
+// close closes every watcher's channel.
+func (w *watchers) close() {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	for _, channels := range w.channels {
+		for ch := range channels {
+			close(ch)
+		}
+	}
+	w.channels = nil
+	w.closed = true
+}
+
+// diffLines returns the strings in after that are not in before, and the
+// strings in before that are not in after.
+func diffLines(before, after []string) ([]string, []string) {
+	inBefore := make(map[string]struct{}, len(before))
+	for _, line := range before {
+		inBefore[line] = struct{}{}
+	}
+	inAfter := make(map[string]struct{}, len(after))
+	for _, line := range after {
+		inAfter[line] = struct{}{}
+	}
+
+	var added, removed []string
+	for _, line := range after {
+		if _, ok := inBefore[line]; !ok {
+			added = append(added, line)
+		}
+	}
+	for _, line := range before {
+		if _, ok := inAfter[line]; !ok {
+			removed = append(removed, line)
+		}
+	}
+	return added, removed
+}